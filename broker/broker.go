@@ -1,57 +1,133 @@
 package main
 
 import (
+	"bytes"
+	"encoding/gob"
+	"errors"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"net"
+	"os"
+	"path/filepath"
 	"time"
 	"net/rpc"
-	"strconv"
+	"sort"
 	"strings"
 	"sync"
 	"uk.ac.bris.cs/gameoflife/gol/stubs"
+	"uk.ac.bris.cs/gameoflife/hashlife"
 	"uk.ac.bris.cs/gameoflife/util"
 )
 
 var kill = make(chan bool, 0)
-var finishTurns = make(chan bool, 1) //to safely quit the Gol for loop
 var runningCalls = sync.WaitGroup{}
-var workerIPs []string
+var workerThreads int
+var turnTimeout time.Duration //a worker that takes longer than this to answer is treated as dead
+var snapshotEvery int //turns between the broker pulling a copy of the world back from the workers
+var heartbeatEvery time.Duration
+var checkpointDir string //where checkpoints are written, empty turns them off
+var checkpointEvery int
+var hashLifeNodes int //squares and results a HashLife job keeps before it forgets them all, 0 never forgets
+var maxWorld int //widest or tallest a world that grows can get
+var maxFrames int //frames kept for a controller that has fallen behind before they get merged into one
+var workerWait time.Duration //how long a job waits for enough workers to register before it fails
+
+const frameWait = 1 * time.Second //longest a controller's call for frames waits for a new one
+const workerPoll = 100 * time.Millisecond //how often a job waiting for workers to register checks the pool
+
+//decides which of two queued jobs should get workers first
+type Policy func(a *Job, b *Job) bool
+
+var policies = map[string]Policy{
+	//first come first served
+	"fifo": func(a *Job, b *Job) bool {
+		return a.QueueSeq < b.QueueSeq
+	},
+	//jobs wanting fewer workers go first, then smaller worlds, which keeps short jobs moving but can starve big ones
+	"smallest": func(a *Job, b *Job) bool {
+		if a.Threads != b.Threads { return a.Threads < b.Threads }
+		if a.Params.ImageWidth*a.Params.ImageHeight != b.Params.ImageWidth*b.Params.ImageHeight {
+			return a.Params.ImageWidth*a.Params.ImageHeight < b.Params.ImageWidth*b.Params.ImageHeight
+		}
+		return a.QueueSeq < b.QueueSeq
+	},
+	//highest priority first, first come first served between equals
+	"priority": func(a *Job, b *Job) bool {
+		if a.Priority != b.Priority { return a.Priority > b.Priority }
+		return a.QueueSeq < b.QueueSeq
+	},
+}
+var policy Policy
+
+const maxRecoveryAttempts = 5
+const deadAfter = 3 //heartbeats a worker can miss in a row before it is dead, any fewer and it is only suspect
 
 type Worker struct {
 	Ip string
-	Working bool
+	Working bool //whether a job has taken this worker out of the pool
 	Lock sync.Mutex
 	Connection *rpc.Client
 	Done chan *rpc.Call
 }
-type Broker struct {
+//what the heartbeat knows about a worker, it has its own connection so pings never queue behind a turn
+type WorkerHealth struct {
+	Status stubs.WorkerStatus
+	Connection *rpc.Client
+}
+
+//everything about one controller's run, the broker can host several of these at once
+type Job struct {
+	ID int
 	Threads int
 	WorldsMut sync.Mutex
 	TurnsMut sync.Mutex
-	WorldA [][]byte // this is an optimisation that reduces the number of memory allocations on each turn
-	CurrentWorldPtr *[][]byte
+	WorldA [][]byte //last world the broker has seen in full, the workers hold the live one between them
 	Turns int
-	Workers []Worker //have 16 workers by default, as this is the max size given in tests
+	Workers []Worker //the workers this job has taken out of the pool
 	Params stubs.Params
-	Alive []util.Cell
+	AliveCount int
 	AliveMut sync.Mutex
 	AliveTurn int
-	AliveTurnMut sync.Mutex
+	Active int //cells the workers worked out on the last turn, guarded by AliveMut
 	OnTurn int
-	Idle bool
+	SnapshotTurn int //the turn WorldA was taken on, which is where we restart from if a worker dies
+	Origin util.Cell //where WorldA's top left cell is on the plane the job started on, which only moves when the topology grows the world
+	Started util.Cell //the size the job started at, before its world grew
+	Bounds util.Rect //around every cell that isn't dead as of the turn loop's current turn, only kept when the topology grows the world
+	Edges stubs.Edges //the world's first and last columns as of the turn loop's current turn, only kept when the topology twists the sides
+	Hash uint64 //of the world as of the turn loop's current turn, only kept when looking for the world repeating
+	Seen map[uint64]int //the turns the last MaxPeriod worlds were on, by their hash
+	SeenHashes []uint64 //the hashes in Seen, oldest first
+	Candidate [][]byte //the world on CandidateTurn, whose hash matched the world's CandidatePeriod turns before
+	CandidateTurn int
+	CandidatePeriod int
+	Idle bool //stopped by its controller, but kept so it can be continued
+	Claimed bool //handed out by CreateJob and waiting for its controller to call AcceptClient
+	Paused bool
+	Priority int //only looked at by the priority policy
+	QueueSeq int //order the job joined the queue in
+	Start chan []Worker //the scheduler hands a queued job its workers down here
+	Watch bool //whether the controller wants frames to draw
+	Frames []stubs.Frame //frames the controller hasn't picked up yet
+	FramesMut sync.Mutex
+	FrameReady chan bool
+	Resume chan bool
+	Finish chan bool //to safely quit the job's turn loop
+	broker *Broker
 }
 
-func (b *Broker) brokerDebug() {
-	fmt.Println("BROKER DEBUG")
-
-	fmt.Println("Threads", b.Threads)
-	fmt.Println("Turns", b.Turns)
-	fmt.Println("Current Turn", b.OnTurn)
-	fmt.Println("Idle", b.Idle)
-	fmt.Println("Params", b.Params)
-
-	fmt.Println()
+type Broker struct {
+	Jobs map[int]*Job
+	JobsMut sync.Mutex
+	LastJobID int
+	Workers []Worker //every worker that has registered, jobs take their workers from here
+	PoolMut sync.Mutex
+	Queue []*Job //jobs waiting for enough free workers
+	QueueMut sync.Mutex
+	QueueSeq int
+	Health map[string]*WorkerHealth
+	HealthMut sync.Mutex
 }
 
 func spreadWorkload(h int, threads int) []int {
@@ -83,69 +159,782 @@ func handleError(err error) {
 }
 
 
-func takeWorkers(b *Broker) []Worker {
-	threads := b.Threads
+//claims threads free workers from the pool, the job still has to dial them
+func takeWorkers(b *Broker, threads int) []Worker {
+	b.PoolMut.Lock(); defer b.PoolMut.Unlock()
 	workers := make([]Worker, 0)
 
-	totalworkers := 0
-	for _, worker := range b.Workers {
-		worker.Lock.Lock()
-		if !worker.Working {
-			workers = append(workers, worker)
-			worker.Working = true
-			totalworkers++
+	free := make([]int, 0)
+	for i := range b.Workers {
+		if len(free) == threads { break }
+		if !b.Workers[i].Working { free = append(free, i) }
+	}
+
+	if len(free) < threads {
+		return workers //if not all workers are available, no workers are available
+	}
+
+	for _, i := range free {
+		b.Workers[i].Working = true
+		workers = append(workers, Worker{Ip: b.Workers[i].Ip, Working: true})
+	}
+	return workers
+}
+
+//hands a job's workers back to the pool, where the next queued job may well be waiting for them
+func releaseWorkers(b *Broker, workers []Worker) {
+	for workerId := range workers {
+		if workers[workerId].Connection != nil {
+			workers[workerId].Connection.Close()
 		}
-		worker.Lock.Unlock()
+		b.setWorking(workers[workerId].Ip, false)
+	}
+	b.schedule()
+}
+
+//puts the queue in the order the policy wants to start jobs in, callers should hold QueueMut
+func (b *Broker) sortQueue() {
+	sort.SliceStable(b.Queue, func(i, j int) bool { return policy(b.Queue[i], b.Queue[j]) })
+}
+
+func (b *Broker) removeFromQueue(job *Job) {
+	for i := range b.Queue {
+		if b.Queue[i] == job {
+			b.Queue = append(b.Queue[:i], b.Queue[i+1:]...)
+			return
+		}
+	}
+}
+
+//starts queued jobs for as long as there are free workers for them
+//nothing overtakes the job at the front, so a big job at the front holds up small ones behind it rather than being starved by them
+func (b *Broker) schedule() {
+	b.QueueMut.Lock(); defer b.QueueMut.Unlock()
+	b.sortQueue()
+
+	for len(b.Queue) > 0 {
+		next := b.Queue[0]
+		workers := takeWorkers(b, next.Threads)
+		if len(workers) == 0 { return }
+
+		b.Queue = b.Queue[1:]
+		next.Start <- workers
+	}
+}
+
+//queues the job until the scheduler hands it workers, reporting false if the job was stopped first
+func (b *Broker) waitInQueue(job *Job) ([]Worker, bool) {
+	b.QueueMut.Lock()
+	b.QueueSeq++
+	job.QueueSeq = b.QueueSeq
+	b.Queue = append(b.Queue, job)
+	b.QueueMut.Unlock()
+	b.schedule()
+
+	if position := b.queuePosition(job); position > 0 {
+		fmt.Println("Job", job.ID, "queued at position", position)
+	}
+
+	select {
+	case workers := <-job.Start:
+		return workers, true
+	case <-job.Finish:
+	}
+
+	b.QueueMut.Lock()
+	b.removeFromQueue(job)
+	b.QueueMut.Unlock()
+
+	//the scheduler may have got to us at the same time as we were stopped
+	select {
+	case workers := <-job.Start:
+		releaseWorkers(b, workers)
+	default:
+	}
+	return nil, false
+}
+
+func (b *Broker) queuePosition(job *Job) int {
+	b.QueueMut.Lock(); defer b.QueueMut.Unlock()
+	b.sortQueue()
+	for position := range b.Queue {
+		if b.Queue[position] == job { return position + 1 }
+	}
+	return 0
+}
+
+//tells a controller where its job is in the queue, a position of 0 means it isn't waiting
+func (b *Broker) QueuePosition(req stubs.JobRequest, res *stubs.QueueResponse) (err error) {
+	runningCalls.Add(1); defer runningCalls.Done()
+
+	job, err := b.getJob(req.JobID)
+	if err != nil { return }
 
-		if totalworkers == threads { return workers }
+	res.Position = b.queuePosition(job)
+	b.QueueMut.Lock()
+	res.Length = len(b.Queue)
+	b.QueueMut.Unlock()
+	res.OnTurn = job.getCurrentTurn()
+	return
+}
+
+func (b *Broker) setWorking(ip string, working bool) {
+	b.PoolMut.Lock(); defer b.PoolMut.Unlock()
+	for i := range b.Workers {
+		if b.Workers[i].Ip == ip { b.Workers[i].Working = working }
 	}
+}
 
-	return make([]Worker, 0) //if not all workers are available, no workers are available
+func (j *Job) getCurrentWorld() [][]byte{
+	j.WorldsMut.Lock(); defer j.WorldsMut.Unlock()
+	return j.WorldA
 }
 
-func (b *Broker) getCurrentWorld() [][]byte{
-	b.WorldsMut.Lock(); defer b.WorldsMut.Unlock()
-	return *b.CurrentWorldPtr
+func (j *Job) setCurrentWorld(world [][]byte) {
+	j.WorldsMut.Lock(); defer j.WorldsMut.Unlock()
+	j.WorldA = world
 }
 
+func (j *Job) setAlive(count int, turn int) {
+	j.AliveMut.Lock(); defer j.AliveMut.Unlock()
+	j.AliveCount, j.AliveTurn = count, turn
+}
+
+func (j *Job) setActive(cells int) {
+	j.AliveMut.Lock(); defer j.AliveMut.Unlock()
+	j.Active = cells
+}
+
+func (j *Job) getActive() int {
+	j.AliveMut.Lock(); defer j.AliveMut.Unlock()
+	return j.Active
+}
+
+//asks each worker for its alive cells, callers should hold TurnsMut so every strip is on the same turn
+//the cells are where they are on the plane the job started on, which is only different if the world has grown
+func (j *Job) getAliveCells(workers []Worker) ([]util.Cell, int) {
+	toPlane := func(cells []util.Cell) []util.Cell {
+		for i := range cells {
+			cells[i].X += j.Origin.X
+			cells[i].Y += j.Origin.Y
+		}
+		return cells
+	}
+
+	//a job without workers has the whole world on the broker
+	if len(workers) == 0 {
+		return toPlane(aliveCells(j.getCurrentWorld(), j.Params.Rule.OrConway())), j.OnTurn
+	}
 
-func (b *Broker) getAliveCells(workers []Worker) ([]util.Cell, int) { //mutex locks aren't helpful here when seting global variabls of broker
-	b.TurnsMut.Lock(); defer b.TurnsMut.Unlock() //sync with pause
 	alive := make([]util.Cell, 0)
 	var onTurn int
-	for workerId := 0; workerId < b.Threads; workerId++  {
+	for workerId := 0; workerId < len(workers); workerId++  {
 		workers[workerId].Lock.Lock()
 		aliveRes := new(stubs.AliveResponse)
-		workers[workerId].Connection.Call(stubs.AliveHandler, stubs.EmptyRequest{}, aliveRes)
+		workers[workerId].Connection.Call(stubs.AliveHandler, stubs.JobRequest{JobID: j.ID}, aliveRes)
 		workers[workerId].Lock.Unlock()
 		alive = append(alive, aliveRes.Alive...)
 		onTurn = aliveRes.OnTurn
 	}
 
-	return alive, onTurn
+	return toPlane(alive), onTurn
+}
+
+//calls a worker but gives up after turnTimeout, so a hung worker can't hang the broker with it
+func callWorker(worker *Worker, method string, req interface{}, res interface{}) error {
+	return callWorkerWithin(worker, method, req, res, turnTimeout)
+}
+
+func callWorkerWithin(worker *Worker, method string, req interface{}, res interface{}, timeout time.Duration) error {
+	worker.Lock.Lock()
+	call := worker.Connection.Go(method, req, res, make(chan *rpc.Call, 1))
+	worker.Lock.Unlock()
+
+	select {
+	case <-call.Done:
+		return call.Error
+	case <-time.After(timeout):
+		return fmt.Errorf("worker %s timed out on %s", worker.Ip, method)
+	}
+}
+
+//rebuilds the whole world from the workers' strips, callers should hold TurnsMut
+//returns the ids of any workers that couldn't be reached
+func (j *Job) gatherWorld(workers []Worker) ([][]byte, []int) {
+	if len(workers) == 0 {
+		return j.getCurrentWorld(), nil
+	}
+
+	var failed []int
+	world := make([][]byte, j.Params.ImageHeight)
+	for workerId := 0; workerId < len(workers); workerId++ {
+		pollRes := new(stubs.Response)
+		err := callWorker(&workers[workerId], stubs.PollWorldHandler, stubs.JobRequest{JobID: j.ID}, pollRes)
+		if err != nil {
+			fmt.Println("Failed to poll worker", workerId, err)
+			failed = append(failed, workerId)
+			continue
+		}
+
+		for rowId, row := range pollRes.Strip.Unpack() {
+			world[pollRes.Slice.From+rowId] = row
+		}
+	}
+
+	return world, failed
+}
+
+func aliveCells(world [][]byte, rule util.Rule) []util.Cell {
+	alive := make([]util.Cell, 0)
+	for y, row := range world {
+		for x, cell := range row {
+			if rule.Alive(cell) { alive = append(alive, util.Cell{X: x, Y: y}) }
+		}
+	}
+	return alive
+}
+
+//packs rows of the world for sending, a bit a cell unless the rule has dying states
+func (j *Job) pack(world [][]byte) util.Packed {
+	return util.Pack(world, j.Params.Rule.Generations())
+}
+
+//packs the world for handing back to the controller, a world that grows is cropped down to whatever isn't dead
+func (j *Job) output(world [][]byte) util.Packed {
+	packed := j.pack(world)
+	if j.Params.Topology.Grows() {
+		return packed.Crop(packed.Bounds())
+	}
+	return packed
+}
+
+//whether anything that isn't dead is within the rule's reach of the edges, where it could spread past them next turn
+func (j *Job) needsRoom() bool {
+	reach := j.Params.Rule.OrConway().Reach()
+	bounds := j.Bounds
+	return !bounds.Empty() && (bounds.Min.X < reach || bounds.Min.Y < reach || bounds.Max.X > j.Params.ImageWidth-reach || bounds.Max.Y > j.Params.ImageHeight-reach)
+}
+
+//makes the world bigger on whichever sides something has come too close to, then splits it between the workers again
+//each side grows by half the world again so the workers don't have to be set up again every few turns
+//callers should hold TurnsMut
+func (j *Job) grow(workers []Worker, turn int) (failed []int, err error) {
+	world, failed := j.gatherWorld(workers)
+	if len(failed) > 0 { return }
+
+	reach := j.Params.Rule.OrConway().Reach()
+	width, height := j.Params.ImageWidth, j.Params.ImageHeight
+	var left, top, right, bottom int
+	if j.Bounds.Min.X < reach { left = width/2 + reach }
+	if j.Bounds.Min.Y < reach { top = height/2 + reach }
+	if j.Bounds.Max.X > width-reach { right = width/2 + reach }
+	if j.Bounds.Max.Y > height-reach { bottom = height/2 + reach }
+	if width+left+right > maxWorld || height+top+bottom > maxWorld {
+		return nil, fmt.Errorf("the world would have to grow past %dx%d, the most the broker allows", maxWorld, maxWorld)
+	}
+
+	grown := make([][]byte, height+top+bottom)
+	for y := range grown {
+		grown[y] = make([]byte, width+left+right)
+		if y >= top && y < top+height {
+			copy(grown[y][left:], world[y-top])
+		}
+	}
+	j.Params.ImageWidth, j.Params.ImageHeight = width+left+right, height+top+bottom
+	j.Origin = util.Cell{X: j.Origin.X - left, Y: j.Origin.Y - top}
+	j.Bounds = j.Bounds.Add(util.Cell{X: left, Y: top})
+	fmt.Println("Job", j.ID, "grown to", j.Params.ImageWidth, "x", j.Params.ImageHeight, "on turn", turn)
+
+	j.setCurrentWorld(grown)
+	j.SnapshotTurn = turn
+	j.restartSeen(grown, turn)
+	//the view's cells have all moved, so start its picture again
+	j.pushFrame(fullFrame(grown, turn))
+	return j.setupWorkers(workers, grown, turn), nil
+}
+
+//remembers the turn loop's current world, and if it has been seen in the last MaxPeriod turns returns how many turns ago
+//callers should hold TurnsMut
+func (j *Job) repeats(turn int) (period int) {
+	if seen, ok := j.Seen[j.Hash]; ok {
+		return turn - seen
+	}
+	if j.Seen == nil { j.Seen = make(map[uint64]int) }
+	j.Seen[j.Hash] = turn
+	j.SeenHashes = append(j.SeenHashes, j.Hash)
+	if len(j.SeenHashes) > j.Params.MaxPeriod {
+		delete(j.Seen, j.SeenHashes[0])
+		j.SeenHashes = j.SeenHashes[1:]
+	}
+	return 0
+}
+
+//checks the world really does repeat once its hash matches an earlier world's, as two worlds can share a hash
+//the world is kept, and only if it comes round again the same cell for cell CandidatePeriod turns later is the
+//period returned, once it does the world on every turn from then on is the world period turns before
+//callers should hold TurnsMut
+func (j *Job) confirmRepeat(workers []Worker, turn int) (period int, failed []int) {
+	if j.Candidate == nil {
+		if period = j.repeats(turn); period == 0 { return 0, nil }
+		j.Candidate, failed = j.gatherWorld(workers)
+		j.CandidateTurn, j.CandidatePeriod = turn, period
+		if len(failed) > 0 { j.Candidate = nil }
+		return 0, failed
+	}
+	if turn < j.CandidateTurn+j.CandidatePeriod { return 0, nil }
+
+	world, failed := j.gatherWorld(workers)
+	if len(failed) > 0 { return 0, failed }
+	if sameWorld(world, j.Candidate) { return j.CandidatePeriod, nil }
+
+	fmt.Println("Job", j.ID, "only had the same hash as the world", j.CandidatePeriod, "turn(s) before on turn", j.CandidateTurn, "so carries on")
+	j.restartSeen(world, turn)
+	return 0, nil
+}
+
+func sameWorld(a [][]byte, b [][]byte) bool {
+	if len(a) != len(b) { return false }
+	for y := range a {
+		if !bytes.Equal(a[y], b[y]) { return false }
+	}
+	return true
+}
+
+//starts looking for the world repeating again from a world the broker has in full
+//needed whenever the turn loop goes back or the world changes size, as the worlds seen before it no longer line up
+//callers should hold TurnsMut
+func (j *Job) restartSeen(world [][]byte, turn int) {
+	j.Seen, j.SeenHashes, j.Candidate = nil, nil, nil
+	if j.Params.MaxPeriod > 0 {
+		j.Hash = j.pack(world).Hash(0)
+		j.repeats(turn)
+	}
+}
+
+//gathers the world for an rpc caller, where a missing worker is an error rather than something to recover from
+func (j *Job) collectWorld(workers []Worker) ([][]byte, error) {
+	world, failed := j.gatherWorld(workers)
+	if len(failed) > 0 {
+		return world, fmt.Errorf("could not collect the world from %d worker(s)", len(failed))
+	}
+	return world, nil
+}
+
+func countAlive(world [][]byte, rule util.Rule) int {
+	count := 0
+	for _, row := range world {
+		for _, cell := range row {
+			if rule.Alive(cell) { count++ }
+		}
+	}
+	return count
+}
+
+//cuts out a worker's rows along with halo rows above and below it, going over the edges however the topology says
+func haloStrip(world [][]byte, y1 int, y2 int, halo int, topology util.Topology) [][]byte {
+	h := len(world)
+	strip := make([][]byte, 0, y2-y1+2*halo)
+	for y := y1 - halo; y < y2+halo; y++ {
+		if y < 0 || y >= h {
+			strip = append(strip, topology.OverEnd(world[(y+h)%h]))
+			continue
+		}
+		row := make([]byte, len(world[y]))
+		copy(row, world[y])
+		strip = append(strip, row)
+	}
+	return strip
+}
+
+//the world's first and last few columns, for workers whose topology needs them
+func edgeColumns(world [][]byte, columns int) stubs.Edges {
+	edges := newEdges(columns, len(world), len(world[0]))
+	for y, row := range world {
+		for i := range edges.Left {
+			edges.Left[i][y] = row[i]
+			edges.Right[i][y] = row[len(row)-1-i]
+		}
+	}
+	return edges
+}
+
+func newEdges(columns int, height int, width int) stubs.Edges {
+	if columns > width { columns = width }
+	edges := stubs.Edges{Left: make([][]uint8, columns), Right: make([][]uint8, columns)}
+	for i := range edges.Left {
+		edges.Left[i] = make([]uint8, height)
+		edges.Right[i] = make([]uint8, height)
+	}
+	return edges
+}
+
+//hands each worker its rows of the world along with who its neighbours are
+//returns the ids of any workers that failed to set up
+func (j *Job) setupWorkers(workers []Worker, world [][]byte, turn int) (failed []int) {
+	workSpread := spreadWorkload(j.Params.ImageHeight, len(workers))
+	params := j.Params
+	params.Rule = params.Rule.OrConway() //workers are always given the rule in full, rather than the zero rule standing for Conway's
+	halo := params.Rule.Reach()
+	j.Edges = stubs.Edges{}
+	if j.Params.Topology.TwistsSides() {
+		j.Edges = edgeColumns(world, halo)
+	}
+
+	for workerId := 0; workerId < len(workers); workerId++ {
+		y1 := workSpread[workerId]; y2 := workSpread[workerId+1]
+		above := workers[(workerId-1+len(workers))%len(workers)].Ip
+		below := workers[(workerId+1)%len(workers)].Ip
+
+		setupReq := stubs.SetupRequest{JobID: j.ID, ID: workerId, Slice: stubs.Slice{From: y1, To: y2, Halo: halo}, Params: params, Strip: j.pack(haloStrip(world, y1, y2, halo, j.Params.Topology)), Turn: turn, Above: above, Below: below, Threads: workerThreads, Edges: j.Edges}
+		err := callWorker(&workers[workerId], stubs.SetupHandler, setupReq, new(stubs.SetupResponse))
+		if err != nil {
+			fmt.Println("Failed to set up worker", workerId, err)
+			failed = append(failed, workerId)
+		}
+	}
+
+	return
+}
+
+//steps every worker on by one turn, the workers swap halos between themselves before replying
+//returns the cells that changed if the job is being watched, and the ids of any workers that errored or timed out
+func (j *Job) takeTurn(workers []Worker, turn int) (aliveCount int, changes stubs.Frame, failed []int) {
+	type turnResult struct {
+		id int
+		res *stubs.Response
+		err error
+	}
+	out := make(chan turnResult, len(workers))
+
+	for workerId := 0; workerId < len(workers); workerId++ {
+		go func(workerId int){
+			turnRes := new(stubs.Response)
+			err := callWorker(&workers[workerId], stubs.TurnHandler, stubs.Request{JobID: j.ID, Turn: turn, Flips: j.Watch, Edges: j.Edges}, turnRes)
+			out <- turnResult{id: workerId, res: turnRes, err: err}
+		}(workerId)
+	}
+
+	//the heartbeat can spot a hung worker long before the turn timeout does
+	var deadCheck <-chan time.Time
+	if heartbeatEvery > 0 {
+		ticker := time.NewTicker(heartbeatEvery)
+		defer ticker.Stop()
+		deadCheck = ticker.C
+	}
+
+	//the workers each send back their rows of the edge columns, which make up the next turn's
+	var edges stubs.Edges
+	if j.Params.Topology.TwistsSides() {
+		edges = newEdges(j.Params.Rule.OrConway().Reach(), j.Params.ImageHeight, j.Params.ImageWidth)
+	}
+
+	//wait for every worker before the next turn can start
+	active := 0
+	var bounds util.Rect
+	var hash uint64
+	for answered := 0; answered < len(workers); {
+		select {
+		case result := <-out:
+			answered++
+			if result.err != nil {
+				fmt.Println("Worker", result.id, "failed on turn", turn, result.err)
+				failed = append(failed, result.id)
+				continue
+			}
+			aliveCount += result.res.AliveCount
+			active += result.res.Active
+			bounds = bounds.Union(result.res.Bounds)
+			hash += result.res.Hash //the rows' hashes add up the same however the world is split
+			changes.Flipped = append(changes.Flipped, result.res.Flipped...)
+			changes.Levels = append(changes.Levels, result.res.Levels...)
+			for i := range edges.Left {
+				copy(edges.Left[i][result.res.Slice.From:], result.res.Edges.Left[i])
+				copy(edges.Right[i][result.res.Slice.From:], result.res.Edges.Right[i])
+			}
+		case <-deadCheck:
+			if dead := j.broker.deadWorkers(workers); len(dead) > 0 {
+				fmt.Println("Heartbeat lost", len(dead), "worker(s) on turn", turn)
+				return aliveCount, changes, append(failed, dead...)
+			}
+		}
+	}
+
+	if len(failed) == 0 {
+		j.Edges = edges
+		j.Bounds = bounds
+		j.Hash = hash
+		j.setActive(active)
+	}
+	return
+}
+
+//a frame that draws the whole world from scratch, with every cell that isn't dead
+func fullFrame(world [][]byte, turn int) stubs.Frame {
+	frame := stubs.Frame{Turn: turn, Flipped: make([]util.Cell, 0), Full: true}
+	for y, row := range world {
+		for x, cell := range row {
+			if cell != 0 {
+				frame.Flipped = append(frame.Flipped, util.Cell{X: x, Y: y})
+				frame.Levels = append(frame.Levels, cell)
+			}
+		}
+	}
+	return frame
+}
+
+//a frame with every cell that differs between two worlds
+func changedFrame(before [][]byte, after [][]byte, turn int) stubs.Frame {
+	frame := stubs.Frame{Turn: turn, Flipped: make([]util.Cell, 0)}
+	for y, row := range after {
+		for x, cell := range row {
+			if cell != before[y][x] {
+				frame.Flipped = append(frame.Flipped, util.Cell{X: x, Y: y})
+				frame.Levels = append(frame.Levels, cell)
+			}
+		}
+	}
+	return frame
+}
+
+//squashes frames into one that takes the controller straight from before the first to after the last
+func mergeFrames(frames []stubs.Frame) stubs.Frame {
+	merged := stubs.Frame{Skipped: -1}
+	levels := make(map[util.Cell]uint8)
+	for _, frame := range frames {
+		//a full frame throws away everything before it
+		if frame.Full {
+			levels = make(map[util.Cell]uint8)
+			merged.Full = true
+		}
+		for i, cell := range frame.Flipped {
+			levels[cell] = frame.Levels[i] //only where a cell ended up matters
+		}
+		merged.Turn = frame.Turn
+		merged.Skipped += frame.Skipped + 1
+		merged.Jumped = merged.Jumped || frame.Jumped
+		merged.Origin = frame.Origin
+	}
+
+	merged.Flipped = make([]util.Cell, 0, len(levels))
+	merged.Levels = make([]uint8, 0, len(levels))
+	for cell, level := range levels {
+		merged.Flipped = append(merged.Flipped, cell)
+		merged.Levels = append(merged.Levels, level)
+	}
+	return merged
+}
+
+//queues a frame for the controller, skipping frames by merging them if it has fallen too far behind
+func (j *Job) pushFrame(frame stubs.Frame) {
+	if !j.Watch { return }
+
+	frame.Origin = j.Origin
+	j.FramesMut.Lock()
+	if frame.Full {
+		j.Frames = nil //nothing before it matters any more
+	}
+	j.Frames = append(j.Frames, frame)
+	if maxFrames > 0 && len(j.Frames) > maxFrames {
+		j.Frames = []stubs.Frame{mergeFrames(j.Frames)}
+	}
+	j.FramesMut.Unlock()
+
+	select {
+	case j.FrameReady <- true:
+	default:
+	}
+}
+
+func (j *Job) takeFrames() []stubs.Frame {
+	j.FramesMut.Lock(); defer j.FramesMut.Unlock()
+	frames := j.Frames
+	j.Frames = nil
+	return frames
+}
+
+//hands the controller every frame since it last asked, waiting a little for one if there aren't any yet
+func (b *Broker) WatchFrames(req stubs.JobRequest, res *stubs.FramesResponse) (err error) {
+	runningCalls.Add(1); defer runningCalls.Done()
+
+	job, err := b.getJob(req.JobID)
+	if err != nil { return }
+
+	select {
+	case <-job.FrameReady:
+	case <-time.After(frameWait):
+	}
+	res.Frames = job.takeFrames()
+	return
+}
+
+//checks whether a worker still answers at all, workers that failed a turn because a neighbour died will
+func (b *Broker) isResponsive(worker *Worker) bool {
+	if b.isDead(worker.Ip) {
+		return false
+	}
+
+	timeout := turnTimeout
+	if heartbeatEvery > 0 { timeout = heartbeatEvery }
+	return callWorkerWithin(worker, stubs.PingHandler, stubs.EmptyRequest{}, new(stubs.PingResponse), timeout) == nil
+}
+
+//dials a worker the job has just taken out of the pool, a worker that won't answer is dropped from the pool
+func (b *Broker) dialWorker(ip string) (*rpc.Client, error) {
+	fmt.Println("Dials", ip)
+	conn, err := net.DialTimeout("tcp", ip, turnTimeout)
+	if err != nil {
+		fmt.Println("Failed to dial", ip, err)
+		b.markDead(ip)
+		return nil, err
+	}
+	return rpc.NewClient(conn), nil
+}
+
+//keeps the workers that still answer and tops them back up with free workers from the pool
+func (j *Job) replaceDeadWorkers(workers []Worker, failed []int) []Worker {
+	b := j.broker
+	isFailed := make(map[int]bool)
+	for _, workerId := range failed {
+		isFailed[workerId] = true
+	}
+
+	survivors := make([]Worker, 0, len(workers))
+	for workerId := range workers {
+		if isFailed[workerId] && !b.isResponsive(&workers[workerId]) {
+			fmt.Println("Dropping dead worker", workers[workerId].Ip)
+			workers[workerId].Connection.Close()
+			b.markDead(workers[workerId].Ip) //the heartbeat puts it back in the pool once it's back up
+			continue
+		}
+		if isFailed[workerId] {
+			//it answered after all, so give it a clean bill of health before the heartbeat writes it off again
+			b.addToPool(workers[workerId].Ip)
+			b.setWorking(workers[workerId].Ip, true)
+			b.resetHealth(workers[workerId].Ip)
+		}
+		survivors = append(survivors, Worker{Ip: workers[workerId].Ip, Working: true, Connection: workers[workerId].Connection})
+	}
+
+	//spare workers are whichever registered workers no other job is using
+	for len(survivors) < j.Params.Threads {
+		spare := takeWorkers(b, 1)
+		if len(spare) == 0 { break }
+
+		client, err := b.dialWorker(spare[0].Ip)
+		if err != nil { continue }
+		fmt.Println("Taking on spare worker", spare[0].Ip)
+		survivors = append(survivors, Worker{Ip: spare[0].Ip, Working: true, Connection: client})
+	}
+
+	return survivors
+}
+
+//drops dead workers and restarts whoever is left from the last snapshot, callers should hold TurnsMut
+//returns the workers now in use and the turn they have been set back to
+func (j *Job) recoverWorkers(workers []Worker, failed []int) ([]Worker, int, error) {
+	for attempt := 0; len(failed) > 0; attempt++ {
+		if attempt == maxRecoveryAttempts {
+			return workers, 0, errors.New("gave up recovering workers")
+		}
+
+		workers = j.replaceDeadWorkers(workers, failed)
+		j.Workers = workers
+		j.Threads = len(workers)
+		if len(workers) == 0 {
+			return workers, 0, errors.New("no workers left to recover onto")
+		}
+
+		fmt.Println("Recovering job", j.ID, "from turn", j.SnapshotTurn, "with", len(workers), "workers")
+		failed = j.setupWorkers(workers, j.getCurrentWorld(), j.SnapshotTurn)
+	}
+
+	return workers, j.SnapshotTurn, nil
+}
+
+//pulls a copy of the world back from the workers to restart from if one of them dies
+func (j *Job) snapshot(workers []Worker, turn int) (failed []int) {
+	world, failed := j.gatherWorld(workers)
+	if len(failed) == 0 {
+		j.setCurrentWorld(world)
+		j.SnapshotTurn = turn
+	}
+	return
+}
+
+func (b *Broker) getJob(jobID int) (*Job, error) {
+	b.JobsMut.Lock(); defer b.JobsMut.Unlock()
+	job, ok := b.Jobs[jobID]
+	if !ok {
+		return nil, fmt.Errorf("no job with id %d", jobID)
+	}
+	return job, nil
+}
+
+func (b *Broker) addJob(job *Job) {
+	b.JobsMut.Lock(); defer b.JobsMut.Unlock()
+	if b.Jobs == nil { b.Jobs = make(map[int]*Job) }
+	job.broker = b
+	job.Start = make(chan []Worker, 1)
+	job.FrameReady = make(chan bool, 1)
+	job.Resume = make(chan bool, 1)
+	job.Finish = make(chan bool, 1)
+	b.Jobs[job.ID] = job
+}
+
+func (b *Broker) removeJob(jobID int) {
+	b.JobsMut.Lock(); defer b.JobsMut.Unlock()
+	delete(b.Jobs, jobID)
+}
+
+//puts a job that lost its workers to sleep so it can be continued, unless it never got anywhere
+func (b *Broker) parkJob(job *Job) {
+	job.TurnsMut.Lock()
+	workers := job.Workers
+	job.Workers = nil
+	job.TurnsMut.Unlock()
+	releaseWorkers(b, workers)
+
+	b.JobsMut.Lock(); defer b.JobsMut.Unlock()
+	if job.SnapshotTurn == 0 {
+		delete(b.Jobs, job.ID)
+		return
+	}
+	job.OnTurn = job.SnapshotTurn
+	job.Idle = true
+	job.Claimed = false
 }
 
 //SDL Key Presses RPCs
-func (b *Broker) SaveWorld(req stubs.EmptyRequest, res *stubs.WorldResponse) (err error) {
+func (b *Broker) SaveWorld(req stubs.JobRequest, res *stubs.WorldResponse) (err error) {
 	runningCalls.Add(1); defer runningCalls.Done()
-	
-	b.TurnsMut.Lock(); defer b.TurnsMut.Unlock()
 
-	res.World = b.getCurrentWorld()
-	res.OnTurn = b.OnTurn
+	job, err := b.getJob(req.JobID)
+	if err != nil { return }
+
+	job.TurnsMut.Lock(); defer job.TurnsMut.Unlock()
+
+	world, err := job.collectWorld(job.Workers)
+	res.World = job.output(world)
+	res.OnTurn = job.OnTurn
 
 	return
 }
 
+//pausing only stops the job between turns, so saving and polling still work while it is paused
 func (b *Broker) PauseGol(req stubs.PauseRequest, res *stubs.PauseResponse) (err error) {
 	runningCalls.Add(1); defer runningCalls.Done()
-	
-	if req.Pause {
-		b.TurnsMut.Lock(); b.WorldsMut.Lock() 
-		res.Turns = b.OnTurn	
-	} else { 
-		res.Turns = b.OnTurn
-		b.TurnsMut.Unlock(); b.WorldsMut.Unlock() 
+
+	job, err := b.getJob(req.JobID)
+	if err != nil { return }
+
+	job.TurnsMut.Lock(); defer job.TurnsMut.Unlock()
+	job.Paused = req.Pause
+	res.Turns = job.OnTurn
+	if !req.Pause {
+		select {
+		case job.Resume <- true:
+		default:
+		}
 	}
 
 	return
@@ -153,294 +942,956 @@ func (b *Broker) PauseGol(req stubs.PauseRequest, res *stubs.PauseResponse) (err
 
 
 
-//connect to the workers in a loop
-func (b *Broker) setUpWorkers() (issue string) {
-	b.Workers = make([]Worker, b.Threads)
-	for i := 0; i < b.Threads; i++ {
-		b.Workers[i].Lock.Lock()
-		b.Workers[i].Ip = "localhost:"+strconv.Itoa(8032+i)
-		b.Workers[i].Ip = workerIPs[i];
+func checkpointPath(jobID int) string {
+	return filepath.Join(checkpointDir, fmt.Sprintf("job-%d.gob", jobID))
+}
 
+//writes the last snapshot to disk, going through a temporary file so a crash mid-write can't eat the old checkpoint
+func (j *Job) writeCheckpoint() (err error) {
+	if checkpointDir == "" { return }
 
-		fmt.Println("Dials", workerIPs[i])
-		client, err := rpc.Dial("tcp", b.Workers[i].Ip)
+	checkpoint := stubs.Checkpoint{
+		CheckpointInfo: stubs.CheckpointInfo{JobID: j.ID, Turn: j.SnapshotTurn, Params: j.Params, Saved: time.Now(), Origin: j.Origin, Started: j.Started},
+		World: j.getCurrentWorld(),
+	}
 
-		handleError(err)
-		if err != nil {
-			b.Workers[i].Lock.Unlock()
-			fmt.Println("Failed to dial")
-			issue = err.Error()
-			return
-		}
+	file, err := ioutil.TempFile(checkpointDir, "checkpoint")
+	if err != nil { return }
+	err = gob.NewEncoder(file).Encode(checkpoint)
+	if err == nil { err = file.Sync() }
+	file.Close()
+	if err != nil {
+		os.Remove(file.Name())
+		return
+	}
 
-		b.Workers[i].Connection = client
-		b.Workers[i].Lock.Unlock()
+	err = os.Rename(file.Name(), checkpointPath(j.ID))
+	if err == nil {
+		fmt.Println("Checkpointed job", j.ID, "at turn", j.SnapshotTurn)
 	}
+	return
+}
+
+func removeCheckpoint(jobID int) {
+	if checkpointDir == "" { return }
+	os.Remove(checkpointPath(jobID))
+}
+
+func readCheckpoint(path string) (checkpoint stubs.Checkpoint, err error) {
+	file, err := os.Open(path)
+	if err != nil { return }
+	defer file.Close()
+
+	err = gob.NewDecoder(file).Decode(&checkpoint)
+	return
+}
+
+//reads every checkpoint in the directory, newest first
+func readCheckpoints() (checkpoints []stubs.Checkpoint, err error) {
+	if checkpointDir == "" { return }
 
+	paths, err := filepath.Glob(filepath.Join(checkpointDir, "job-*.gob"))
+	if err != nil { return }
+
+	for _, path := range paths {
+		checkpoint, readErr := readCheckpoint(path)
+		if readErr != nil {
+			fmt.Println("Skipping unreadable checkpoint", path, readErr)
+			continue
+		}
+		checkpoints = append(checkpoints, checkpoint)
+	}
+	sort.Slice(checkpoints, func(i, j int) bool { return checkpoints[i].Saved.After(checkpoints[j].Saved) })
 	return
 }
 
-func (b *Broker) getCurrentAliveCells() []util.Cell {
-	b.AliveMut.Lock(); defer b.AliveMut.Unlock()
+//lists the checkpoints a restarted broker could pick a job back up from
+func (b *Broker) ListCheckpoints(req stubs.EmptyRequest, res *stubs.CheckpointsResponse) (err error) {
+	runningCalls.Add(1); defer runningCalls.Done()
 
-	return b.Alive
+	checkpoints, err := readCheckpoints()
+	for _, checkpoint := range checkpoints {
+		res.Checkpoints = append(res.Checkpoints, checkpoint.CheckpointInfo)
+	}
+	return
 }
 
-func (b *Broker) getTurn() int {
-	b.TurnsMut.Lock(); defer b.TurnsMut.Unlock()
+//turns a checkpoint back into a job, as long as the broker doesn't already have a job with its id
+func (b *Broker) resumeCheckpoint(checkpoint stubs.Checkpoint, threads int, priority int) *Job {
+	if _, err := b.getJob(checkpoint.JobID); err == nil {
+		return nil
+	}
 
-	return b.OnTurn
+	fmt.Println("Resuming job", checkpoint.JobID, "from its checkpoint at turn", checkpoint.Turn)
+	job := &Job{ID: checkpoint.JobID, Params: checkpoint.Params, WorldA: checkpoint.World, OnTurn: checkpoint.Turn, SnapshotTurn: checkpoint.Turn, Priority: priority, Claimed: true, Origin: checkpoint.Origin, Started: checkpoint.Started}
+	if threads > 0 {
+		job.Params.Threads = threads //whatever workers we have now, not whatever we had then
+	}
+	job.Threads = job.Params.Threads
+	job.Turns = job.Params.Turns
+	b.addJob(job)
+	return job
 }
 
-func (b *Broker) KillBroker(req stubs.EmptyRequest, res *stubs.KillBrokerResponse) (err error) {
-	// runningCalls.Add(1); defer func(){ ; runningCalls.Done() }()
+//finds the job a continuing controller is after, either one stopped earlier or one checkpointed before the broker restarted
+//a JobID of 0 asks for the most recent one of the same size
+func (b *Broker) findContinuingJob(req stubs.NewJobRequest) (*Job, error) {
+	//a world that has grown is matched on the size it started at
+	sameSize := func(started util.Cell) bool {
+		return started.X == req.Params.ImageWidth && started.Y == req.Params.ImageHeight
+	}
+
+	b.JobsMut.Lock()
+	var idle *Job
+	for _, job := range b.Jobs {
+		if !job.Idle || job.Claimed { continue }
+		if req.JobID == job.ID || req.JobID == 0 && sameSize(job.Started) && (idle == nil || job.ID > idle.ID) {
+			idle = job
+		}
+	}
+	if idle != nil {
+		idle.Claimed = true
+		idle.Priority = req.Priority
+		if req.Params.Threads > 0 {
+			idle.TurnsMut.Lock()
+			idle.Params.Threads = req.Params.Threads
+			idle.Threads = req.Params.Threads
+			idle.TurnsMut.Unlock()
+		}
+	}
+	b.JobsMut.Unlock()
+	if idle != nil {
+		fmt.Println("Continuing job", idle.ID)
+		return idle, nil
+	}
+
+	checkpoints, err := readCheckpoints()
+	if err != nil {
+		return nil, err
+	}
+	for _, checkpoint := range checkpoints {
+		if req.JobID == checkpoint.JobID || req.JobID == 0 && sameSize(checkpoint.Started) {
+			if job := b.resumeCheckpoint(checkpoint, req.Params.Threads, req.Priority); job != nil {
+				return job, nil
+			}
+		}
+	}
+
+	if req.JobID != 0 {
+		return nil, fmt.Errorf("no stopped job or checkpoint with id %d", req.JobID)
+	}
+	return nil, nil
+}
+
+//job ids carry on from the highest checkpoint on disk so a restarted broker doesn't reuse one
+func (b *Broker) newJobID() int {
+	b.JobsMut.Lock(); defer b.JobsMut.Unlock()
+	if b.LastJobID == 0 {
+		checkpoints, _ := readCheckpoints()
+		for _, checkpoint := range checkpoints {
+			if checkpoint.JobID > b.LastJobID { b.LastJobID = checkpoint.JobID }
+		}
+	}
+
+	b.LastJobID++
+	return b.LastJobID
+}
+
+//hands the controller the id it should use for every other call about its job
+func (b *Broker) CreateJob(req stubs.NewJobRequest, res *stubs.JobResponse) (err error) {
 	runningCalls.Add(1); defer runningCalls.Done()
-	
-	b.WorldsMut.Lock(); b.TurnsMut.Lock();
-	finishTurns <- true
-	for workerId := 0; workerId < b.Threads; workerId++ {
-		b.Workers[workerId].Lock.Lock()
 
-		fmt.Println("Attempting to kill worker", workerId)
-		b.Workers[workerId].Connection.Call(stubs.KillHandler, stubs.EmptyRequest{}, &stubs.EmptyResponse{})
-		b.Workers[workerId].Connection.Close()
-		fmt.Println("Killed worker", workerId)
+	if req.Continue {
+		job, err := b.findContinuingJob(req)
+		if err != nil { return err }
+		if job != nil {
+			job.Watch = req.Watch
+			res.JobID = job.ID
+			res.Continuing = true
+			return nil
+		}
+	}
 
-		b.Workers[workerId].Lock.Unlock()
+	job := &Job{ID: b.newJobID(), Params: req.Params, Threads: req.Params.Threads, Turns: req.Params.Turns, Priority: req.Priority, Watch: req.Watch, Claimed: true, Started: util.Cell{X: req.Params.ImageWidth, Y: req.Params.ImageHeight}}
+	b.addJob(job)
+	res.JobID = job.ID
+	fmt.Println("Created job", job.ID)
+	return
+}
+
+func (b *Broker) getPool() []string {
+	b.PoolMut.Lock(); defer b.PoolMut.Unlock()
+	pool := make([]string, len(b.Workers))
+	for i := range b.Workers {
+		pool[i] = b.Workers[i].Ip
 	}
+	return pool
+}
 
-	res.Alive = b.getCurrentAliveCells()
-	res.OnTurn = b.OnTurn
-	res.World = b.WorldA
-	
+//reports whether the worker is new to the pool
+func (b *Broker) addToPool(ip string) bool {
+	b.PoolMut.Lock(); defer b.PoolMut.Unlock()
+	for i := range b.Workers {
+		if b.Workers[i].Ip == ip { return false }
+	}
+	b.Workers = append(b.Workers, Worker{Ip: ip})
+	return true
+}
 
-	b.WorldsMut.Unlock(); b.TurnsMut.Unlock()
-	
+//waits up to workerWait for at least threads workers to be in the pool, reporting whether there are enough
+//workers only find a broker that has just restarted when they register again, which takes them a few seconds
+func (b *Broker) waitForWorkers(threads int) bool {
+	deadline := time.Now().Add(workerWait)
+	for len(b.getPool()) < threads {
+		if time.Now().After(deadline) { return false }
+		time.Sleep(workerPoll)
+	}
+	return true
+}
 
-	fmt.Println(len(res.World), len(res.World[0]))
-	kill <- true
-	
-	fmt.Println("Set to close when ready")
-	time.Sleep(1 * time.Second)
+func (b *Broker) inPool(ip string) bool {
+	b.PoolMut.Lock(); defer b.PoolMut.Unlock()
+	for i := range b.Workers {
+		if b.Workers[i].Ip == ip { return true }
+	}
+	return false
+}
+
+func (b *Broker) removeFromPool(ip string) {
+	b.PoolMut.Lock(); defer b.PoolMut.Unlock()
+	for i := range b.Workers {
+		if b.Workers[i].Ip == ip {
+			b.Workers = append(b.Workers[:i], b.Workers[i+1:]...)
+			return
+		}
+	}
+}
+
+//workers call this when they start up, after which any job can draw on them
+func (b *Broker) RegisterWorker(req stubs.WorkerRequest, res *stubs.EmptyResponse) (err error) {
+	runningCalls.Add(1); defer runningCalls.Done()
+
+	if req.Ip == "" {
+		return errors.New("worker registered without an address")
+	}
+	//workers register again every so often in case we've restarted, which is only news if we had lost them
+	if b.addToPool(req.Ip) { fmt.Println("Registered worker", req.Ip) }
+	b.resetHealth(req.Ip)
+	b.schedule()
 	return
 }
 
-func (b *Broker) Finish(req stubs.EmptyRequest, res *stubs.QuitWorldResponse) (err error) {
+//workers call this as they shut down, a job already using them will recover when they go
+func (b *Broker) DeregisterWorker(req stubs.WorkerRequest, res *stubs.EmptyResponse) (err error) {
 	runningCalls.Add(1); defer runningCalls.Done()
-	
-	//finish itself
-	b.TurnsMut.Lock()
-	b.WorldsMut.Lock()
-	b.Idle = true
-	
-	//call all the servers to finish
-	for workerId := 0; workerId < b.Threads; workerId++ {
-		b.Workers[workerId].Lock.Lock()
 
-		err := b.Workers[workerId].Connection.Call(stubs.FinishHander, stubs.EmptyRequest{}, new(stubs.EmptyResponse))
-		handleError(err)
+	b.removeFromPool(req.Ip)
+	b.forgetHealth(req.Ip)
+	fmt.Println("Deregistered worker", req.Ip)
+	return
+}
+
+//starts a worker off as healthy, whether it is new or coming back after dying
+func (b *Broker) resetHealth(ip string) {
+	b.HealthMut.Lock(); defer b.HealthMut.Unlock()
+	if b.Health == nil { b.Health = make(map[string]*WorkerHealth) }
 
-		b.Workers[workerId].Lock.Unlock()
+	health, ok := b.Health[ip]
+	if !ok {
+		health = &WorkerHealth{}
+		b.Health[ip] = health
 	}
+	health.Status = stubs.WorkerStatus{Ip: ip, State: stubs.Healthy, LastSeen: time.Now()}
+}
 
-	res.OnTurn = b.OnTurn
-	res.Alive = b.Alive
+func (b *Broker) forgetHealth(ip string) {
+	b.HealthMut.Lock(); defer b.HealthMut.Unlock()
+	health, ok := b.Health[ip]
+	if !ok { return }
 
-	fmt.Println("Going to sleep.")
+	if health.Connection != nil { health.Connection.Close() }
+	delete(b.Health, ip)
+}
 
+//takes a worker out of the pool, the heartbeat keeps pinging it and puts it back if it answers again
+func (b *Broker) markDead(ip string) {
+	b.removeFromPool(ip)
 
-	return
+	b.HealthMut.Lock(); defer b.HealthMut.Unlock()
+	if b.Health == nil { b.Health = make(map[string]*WorkerHealth) }
+	health, ok := b.Health[ip]
+	if !ok {
+		health = &WorkerHealth{Status: stubs.WorkerStatus{Ip: ip}}
+		b.Health[ip] = health
+	}
+	health.Status.State = stubs.Dead
 }
 
+//every worker the heartbeat pings: the pool, and the dead workers that may yet come back
+func (b *Broker) heartbeatTargets() []string {
+	targets := b.getPool()
+	inPool := make(map[string]bool)
+	for _, ip := range targets {
+		inPool[ip] = true
+	}
 
-//fault tolerance - resuming work
-func (b *Broker) wakeUp() {
-	fmt.Println("Waking up")
-	b.Idle = false
-	// b.AliveTurn = b.OnTurn
-	// b.setUpWorkers()
-	b.TurnsMut.Unlock()
-	b.WorldsMut.Unlock()
+	b.HealthMut.Lock(); defer b.HealthMut.Unlock()
+	for ip, health := range b.Health {
+		if !inPool[ip] && health.Status.State == stubs.Dead {
+			targets = append(targets, ip)
+		}
+	}
+	return targets
 }
 
-func (b *Broker) setCurrentWorldRow(rowIndex int, row []byte) {
+//the heartbeat's connection to a worker, dialling a new one if it has none
+//only ever used outside HealthMut, so forgetHealth closing it under us just fails the ping
+func (b *Broker) heartbeatConnection(ip string, health *WorkerHealth) (*rpc.Client, error) {
+	b.HealthMut.Lock()
+	client := health.Connection
+	b.HealthMut.Unlock()
+	if client != nil { return client, nil }
+
+	conn, err := net.DialTimeout("tcp", ip, heartbeatEvery)
+	if err != nil { return nil, err }
+	client = rpc.NewClient(conn)
+
+	//the worker may have deregistered while we were dialling it
+	b.HealthMut.Lock(); defer b.HealthMut.Unlock()
+	if b.Health[ip] != health {
+		client.Close()
+		return nil, errors.New("worker deregistered")
+	}
+	health.Connection = client
+	return client, nil
+}
+
+//nil for a worker that has left the pool without the heartbeat knowing it, as it has just deregistered
+func (b *Broker) getHealth(ip string) *WorkerHealth {
+	inPool := b.inPool(ip)
+	b.HealthMut.Lock(); defer b.HealthMut.Unlock()
+	if b.Health == nil { b.Health = make(map[string]*WorkerHealth) }
+
+	health, ok := b.Health[ip]
+	if !ok {
+		if !inPool { return nil }
+		//workers given through -worker_ips never register, so they are picked up here instead
+		health = &WorkerHealth{Status: stubs.WorkerStatus{Ip: ip, State: stubs.Healthy, LastSeen: time.Now()}}
+		b.Health[ip] = health
+	}
+	return health
+}
+
+//pings a single worker, marking it suspect or dead if it doesn't answer in time, and healthy again if it does
+func (b *Broker) beat(ip string) {
+	health := b.getHealth(ip)
+	if health == nil { return }
+	client, err := b.heartbeatConnection(ip, health)
+	if err != nil {
+		b.missedBeat(ip, health)
+		return
+	}
 
-	(*b.CurrentWorldPtr)[rowIndex] = row
-	
+	start := time.Now()
+	pingRes := new(stubs.PingResponse)
+	call := client.Go(stubs.PingHandler, stubs.EmptyRequest{}, pingRes, make(chan *rpc.Call, 1))
 
+	select {
+	case <-call.Done:
+		err = call.Error
+	case <-time.After(heartbeatEvery):
+		err = errors.New("heartbeat timed out")
+	}
+
+	b.HealthMut.Lock()
+	//a worker that deregistered during the ping has already had its connection closed and its health forgotten
+	if b.Health[ip] != health {
+		b.HealthMut.Unlock()
+		return
+	}
+	if err != nil {
+		if health.Connection == client { health.Connection = nil }
+		b.HealthMut.Unlock()
+		client.Close()
+		b.missedBeat(ip, health)
+		return
+	}
+
+	revived := health.Status.State == stubs.Dead
+	health.Status.State = stubs.Healthy
+	health.Status.LastSeen = time.Now()
+	health.Status.Latency = time.Since(start)
+	health.Status.Missed = 0
+	health.Status.Turn = pingRes.Turn
+	health.Status.JobID = pingRes.JobID
+	b.HealthMut.Unlock()
+
+	if revived {
+		fmt.Println("Worker", ip, "is answering again, putting it back in the pool")
+		b.addToPool(ip)
+		b.schedule()
+	}
 }
 
-func (b *Broker) getCurrentTurn() int {
-	b.TurnsMut.Lock(); defer b.TurnsMut.Unlock()
+func (b *Broker) missedBeat(ip string, health *WorkerHealth) {
+	b.HealthMut.Lock()
+	if b.Health[ip] != health {
+		b.HealthMut.Unlock()
+		return
+	}
+	health.Status.Missed++
+	wasDead := health.Status.State == stubs.Dead
+	dead := health.Status.Missed >= deadAfter || wasDead
+	if dead {
+		health.Status.State = stubs.Dead
+	} else {
+		health.Status.State = stubs.Suspect
+	}
+	b.HealthMut.Unlock()
+
+	if dead && !wasDead {
+		fmt.Println("Worker", ip, "missed", deadAfter, "heartbeats, marking it dead")
+		b.markDead(ip)
+	}
+}
+
+//pings every worker in the pool on an interval, for as long as the broker is up
+func (b *Broker) heartbeat() {
+	ticker := time.NewTicker(heartbeatEvery)
+	for range ticker.C {
+		var wg sync.WaitGroup
+		for _, ip := range b.heartbeatTargets() {
+			wg.Add(1)
+			go func(ip string){
+				defer wg.Done()
+				b.beat(ip)
+			}(ip)
+		}
+		wg.Wait()
+	}
+}
+
+func (b *Broker) isDead(ip string) bool {
+	b.HealthMut.Lock(); defer b.HealthMut.Unlock()
+	health, ok := b.Health[ip]
+	return ok && health.Status.State == stubs.Dead
+}
+
+//finds the workers in a job that the heartbeat has already given up on
+func (b *Broker) deadWorkers(workers []Worker) (dead []int) {
+	b.HealthMut.Lock(); defer b.HealthMut.Unlock()
+	for workerId := range workers {
+		if health, ok := b.Health[workers[workerId].Ip]; ok && health.Status.State == stubs.Dead {
+			dead = append(dead, workerId)
+		}
+	}
+	return
+}
+
+//reports what the heartbeat knows about every worker, for monitoring
+func (b *Broker) ClusterStatus(req stubs.EmptyRequest, res *stubs.ClusterStatusResponse) (err error) {
+	runningCalls.Add(1); defer runningCalls.Done()
+
+	b.HealthMut.Lock()
+	res.Workers = make([]stubs.WorkerStatus, 0, len(b.Health))
+	for _, health := range b.Health {
+		res.Workers = append(res.Workers, health.Status)
+	}
+	b.HealthMut.Unlock()
+	sort.Slice(res.Workers, func(i, j int) bool { return res.Workers[i].Ip < res.Workers[j].Ip })
+
+	//Idle is only written under JobsMut, everything else comes from each job under its own lock once JobsMut is let go
+	b.JobsMut.Lock()
+	jobs := make([]*Job, 0, len(b.Jobs))
+	idle := make(map[*Job]bool, len(b.Jobs))
+	for _, job := range b.Jobs {
+		jobs = append(jobs, job)
+		idle[job] = job.Idle
+	}
+	b.JobsMut.Unlock()
 
-	return b.OnTurn
+	for _, job := range jobs {
+		status := job.status()
+		status.Idle = idle[job]
+		status.QueuePosition = b.queuePosition(job)
+		res.Jobs = append(res.Jobs, status)
+	}
+	sort.Slice(res.Jobs, func(i, j int) bool { return res.Jobs[i].JobID < res.Jobs[j].JobID })
+	return
 }
 
-func (b *Broker) checkWorkerAddresses(threads int) (issue string) {
+//the parts of a job's status written under TurnsMut
+func (j *Job) status() stubs.JobStatus {
+	j.TurnsMut.Lock(); defer j.TurnsMut.Unlock()
+	return stubs.JobStatus{JobID: j.ID, Params: j.Params, Workers: len(j.Workers), Paused: j.Paused, Active: j.getActive()}
+}
 
-	if threads > len(workerIPs) {
-		return "not enough addresses"
+//stops a job's turn loop and pulls its world back in, falling back on the last snapshot if a worker has gone
+//callers should hold TurnsMut
+func (j *Job) stop() {
+	select {
+	case j.Finish <- true:
+	default:
 	}
 
-	issue = b.setUpWorkers()
-	return issue
+	world, err := j.collectWorld(j.Workers)
+	if err != nil {
+		fmt.Println("Falling back on the snapshot from turn", j.SnapshotTurn, err)
+		j.OnTurn = j.SnapshotTurn
+	} else {
+		j.setCurrentWorld(world)
+		j.SnapshotTurn = j.OnTurn
+	}
+
+	if err := j.writeCheckpoint(); err != nil {
+		fmt.Println("Error writing checkpoint:", err)
+	}
 }
 
-func (b *Broker) AcceptClient (req stubs.NewClientRequest, res *stubs.NewClientResponse) (err error) {
+func (b *Broker) KillBroker(req stubs.JobRequest, res *stubs.KillBrokerResponse) (err error) {
+	// runningCalls.Add(1); defer func(){ ; runningCalls.Done() }()
 	runningCalls.Add(1); defer runningCalls.Done()
-	var i int
 
-	if b.Idle { 
-		b.wakeUp()
+	job, err := b.getJob(req.JobID)
+	if err != nil { return }
 
-		if !req.Continue {
-			
-			b.WorldsMut.Lock()
-			b.CurrentWorldPtr = &b.WorldA
-			*b.CurrentWorldPtr = req.World ///deref currentworld in order to change its actual content to the new world
-			b.WorldsMut.Unlock()
-		
-			b.Params = req.Params
-			b.Threads = req.Params.Threads
+	job.TurnsMut.Lock()
 
-			b.TurnsMut.Lock()
-			b.Turns = req.Params.Turns
-			b.TurnsMut.Unlock()
+	//the workers hold the world between them, so collect it before they go
+	job.stop()
+	res.World = job.output(job.getCurrentWorld())
+	res.Alive, _ = job.getAliveCells(job.Workers)
+	res.OnTurn = job.OnTurn
+	job.TurnsMut.Unlock()
 
-			i = 0
-		} else { 
+	//every other job goes down with the broker too, but is checkpointed so it can be picked back up
+	b.JobsMut.Lock()
+	others := make([]*Job, 0, len(b.Jobs))
+	for _, other := range b.Jobs {
+		if other != job { others = append(others, other) }
+	}
+	b.JobsMut.Unlock()
 
-			fmt.Println("Continuing")
-			i = b.getCurrentTurn()
+	for _, other := range others {
+		other.TurnsMut.Lock()
+		if !other.Idle && other.getCurrentWorld() != nil {
+			fmt.Println("Stopping job", other.ID)
+			other.stop()
 		}
-	} else {
-		b.WorldsMut.Lock()
-		b.CurrentWorldPtr = &b.WorldA
-		*b.CurrentWorldPtr = req.World ///deref currentworld in order to change its actual content to the new world
-		b.WorldsMut.Unlock()
-	
-		b.Params = req.Params
-		b.Threads = req.Params.Threads
-	
-		b.TurnsMut.Lock()
-		b.Turns = req.Params.Turns
-		b.TurnsMut.Unlock()
+		other.TurnsMut.Unlock()
+	}
 
-		i = 0
+	for _, ip := range b.getPool() {
+		fmt.Println("Attempting to kill worker", ip)
+		client, err := rpc.Dial("tcp", ip)
+		if err != nil { continue }
+		client.Call(stubs.KillHandler, stubs.EmptyRequest{}, &stubs.EmptyResponse{})
+		client.Close()
+		fmt.Println("Killed worker", ip)
 	}
 
 
-	issue := b.checkWorkerAddresses(req.Params.Threads)
-	if(issue != ""){
-		fmt.Println("Error checking workers & addresses:", issue)
+	kill <- true
+
+	fmt.Println("Set to close when ready")
+	time.Sleep(1 * time.Second)
+	return
+}
+
+func (b *Broker) Finish(req stubs.JobRequest, res *stubs.QuitWorldResponse) (err error) {
+	runningCalls.Add(1); defer runningCalls.Done()
+
+	job, err := b.getJob(req.JobID)
+	if err != nil { return }
+
+	//finish itself
+	job.TurnsMut.Lock(); defer job.TurnsMut.Unlock()
+
+	//keep hold of the world so a continuing client can hand it back out to the workers
+	job.stop()
+	res.Alive, _ = job.getAliveCells(job.Workers)
+	res.OnTurn = job.OnTurn
+
+	//call all the servers to finish, then hand them back for other jobs to use
+	for workerId := range job.Workers {
+		err := callWorker(&job.Workers[workerId], stubs.FinishHander, stubs.JobRequest{JobID: job.ID}, new(stubs.EmptyResponse))
+		if err != nil {
+			fmt.Println("Error finishing worker", job.Workers[workerId].Ip, err)
+		}
+	}
+	releaseWorkers(b, job.Workers)
+	job.Workers = nil
+
+	b.JobsMut.Lock()
+	job.Idle = true
+	job.Claimed = false
+	job.Paused = false
+	b.JobsMut.Unlock()
+
+	fmt.Println("Job", job.ID, "going to sleep.")
+
+
+	return
+}
+
+func (j *Job) getCurrentTurn() int {
+	j.TurnsMut.Lock(); defer j.TurnsMut.Unlock()
+
+	return j.OnTurn
+}
+
+//dials the workers the scheduler gave the job, swapping out any that have gone away
+func (j *Job) checkWorkerAddresses(workers []Worker) (issue string) {
+	b := j.broker
+
+	for workerId := range workers {
+		for workers[workerId].Connection == nil {
+			client, err := b.dialWorker(workers[workerId].Ip)
+			if err == nil {
+				workers[workerId].Connection = client
+				continue
+			}
+
+			replacement := takeWorkers(b, 1)
+			if len(replacement) == 0 {
+				releaseWorkers(b, workers)
+				return "not enough reachable workers"
+			}
+			workers[workerId].Ip = replacement[0].Ip
+		}
+	}
+
+	j.TurnsMut.Lock()
+	j.Workers = workers
+	j.TurnsMut.Unlock()
+	return
+}
+
+func (b *Broker) AcceptClient (req stubs.NewClientRequest, res *stubs.NewClientResponse) (err error) {
+	runningCalls.Add(1); defer runningCalls.Done()
+
+	job, err := b.getJob(req.JobID)
+	if err != nil { return }
+
+	failJob := func(issue string) {
+		fmt.Println("Error running job", job.ID, ":", issue)
 		res.Alive = []util.Cell{}
 		res.Turns = -1
-		res.World = [][]uint8{}
+		res.World = util.Packed{}
+		res.Issue = issue
+	}
+
+	//only the controller that was handed this job by CreateJob gets to run it
+	b.JobsMut.Lock()
+	if !job.Claimed {
+		b.JobsMut.Unlock()
+		return fmt.Errorf("job %d is not waiting to be run", job.ID)
+	}
+	job.Claimed = false
+	job.Idle = false
+	b.JobsMut.Unlock()
+
+	//a new job takes the controller's world, a continuing one already has its own
+	if job.getCurrentWorld() == nil {
+		job.setCurrentWorld(req.World.Unpack())
+	}
+	select {
+	case <-job.Finish: //left over from the last time this job was stopped
+	default:
+	}
+	i := job.getCurrentTurn()
+
+	//each worker needs at least as many rows as the rule reaches, or its halos would have to come from further than its neighbours
+	reach := job.Params.Rule.OrConway().Reach()
+	if job.Params.ImageHeight < reach {
+		failJob(fmt.Sprintf("the world is only %d rows high but the rule reaches %d", job.Params.ImageHeight, reach))
+		b.parkJob(job)
+		return
+	}
+	//HashLife runs on the broker, so it doesn't need workers or a place in the queue
+	if job.Params.Engine == util.HashLife {
+		universe, hashErr := hashlife.New(job.Params.Rule, job.Params.Topology)
+		if hashErr != nil {
+			failJob(hashErr.Error())
+			b.parkJob(job)
+			return
+		}
+		b.runHashLife(job, universe, res)
 		return
 	}
 
-	workers := b.Workers
-	noWorkers := b.Threads
+	if most := job.Params.ImageHeight / reach; job.Threads > most {
+		fmt.Println("Job", job.ID, "can only be split between", most, "workers")
+		job.TurnsMut.Lock()
+		job.Threads = most
+		job.Params.Threads = most
+		job.TurnsMut.Unlock()
+	}
 
-	out := make(chan *stubs.Response, b.Threads)
+	if !b.waitForWorkers(job.Threads) {
+		failJob("not enough registered workers")
+		b.parkJob(job)
+		return
+	}
 
+	//whoever stops us while we're queued has already dealt with the world
+	queued, ok := b.waitInQueue(job)
+	if !ok {
+		res.Stopped = true
+		return
+	}
 
-	//send work to the gol workers
-	workSpread := spreadWorkload(b.Params.ImageHeight, b.Threads)
+	issue := job.checkWorkerAddresses(queued)
+	if(issue != ""){
+		failJob(issue)
+		b.parkJob(job)
+		return
+	}
 
-	for workerId := 0; workerId < len(workers); workerId++ {
-		y1 := workSpread[workerId]; y2 := workSpread[workerId+1]
+	workers := job.Workers
+	world := job.getCurrentWorld()
 
-		setupReq := stubs.SetupRequest{ID: workerId, Slice: stubs.Slice{From: y1, To: y2}, Params: b.Params, World: b.getCurrentWorld(), Turn: i}
-		workers[workerId].Lock.Lock()
-		err = workers[workerId].Connection.Call(stubs.SetupHandler, setupReq, new(stubs.SetupResponse))
-		workers[workerId].Lock.Unlock()
+	job.TurnsMut.Lock()
+	job.SnapshotTurn = i
 
-		handleError(err)
+	//send work to the gol workers, each only gets its own rows and the halos around them
+	failed := job.setupWorkers(workers, world, i)
+	if len(failed) > 0 {
+		workers, i, err = job.recoverWorkers(workers, failed)
+	}
+	job.TurnsMut.Unlock()
+	if err != nil {
+		failJob(err.Error())
+		err = nil
+		b.parkJob(job)
+		return
 	}
 
+	job.setAlive(countAlive(world, job.Params.Rule.OrConway()), i)
+	job.Bounds = job.pack(world).Bounds()
+	job.restartSeen(world, i)
+	job.pushFrame(fullFrame(world, i))
 
+	//once the world repeats, only the turns needed to land on the same world as the last turn would are left
+	stopAt := job.Turns
+	period, firstTurn := 0, 0
 
 	exitLoop := false
-	for i < b.Turns && !exitLoop {
+	finished := false
+	for !finished && !exitLoop {
+		//hold the turn lock for the whole turn so pausing and polling always see a finished turn
+		job.TurnsMut.Lock()
+		if job.Paused {
+			job.TurnsMut.Unlock()
+			select {
+			case <-job.Resume:
+			case <-job.Finish:
+				exitLoop = true
+			}
+			continue
+		}
+
 		select {
-			case <-finishTurns:
+			case <-job.Finish:
 				exitLoop = true
 			default:
-				turnResponses := make([]stubs.Response, noWorkers)
-				//send a turn request to each worker selected
-				for workerId := 0; workerId < b.Threads; workerId++ {
-					turnReq := stubs.Request{World: b.getCurrentWorld()}
-					//receive response when ready (in any order) via the out channel
-					go func(workerId int){
-						turnRes := new(stubs.Response)
-						workers[workerId].Lock.Lock()
-						workers[workerId].Connection.Call(stubs.TurnHandler, turnReq, turnRes)
-						workers[workerId].Lock.Unlock()
-						out <- turnRes
-					}(workerId)
+				var failed []int
+				//no point waiting out a turn timeout on a worker the heartbeat already knows is gone
+				failed = b.deadWorkers(workers)
+				if len(failed) > 0 {
+					fmt.Println("Heartbeat lost", len(failed), "worker(s)")
+				} else if i < stopAt && job.Params.Topology.Grows() && job.needsRoom() {
+					//something could spread past the edges next turn, so make room for it first
+					var growErr error
+					failed, growErr = job.grow(workers, i)
+					if growErr != nil {
+						job.TurnsMut.Unlock()
+						failJob(growErr.Error())
+						b.parkJob(job)
+						return
+					}
+				} else if i < stopAt {
+					var aliveCount int
+					var changes stubs.Frame
+					aliveCount, changes, failed = job.takeTurn(workers, i)
+					if len(failed) == 0 {
+						job.setAlive(aliveCount, i+1)
+						changes.Turn = i+1
+						job.pushFrame(changes)
+
+						i++
+						job.OnTurn = i
+
+						if job.Params.MaxPeriod > 0 && period == 0 {
+							//the world period turns before CandidateTurn only matched by hash, so the cycle is reported from
+							//CandidateTurn, whose world was checked cell for cell coming round again
+							if period, failed = job.confirmRepeat(workers, i); period > 0 {
+								firstTurn = job.CandidateTurn
+								stopAt = i + (job.Turns - i) % period
+								fmt.Println("Job", job.ID, "repeats every", period, "turn(s) from turn", firstTurn, "so stops on turn", stopAt, "rather than", job.Turns)
+							}
+						}
+
+						isCheckpoint := checkpointDir != "" && checkpointEvery > 0 && i % checkpointEvery == 0
+						if len(failed) == 0 && (isCheckpoint || snapshotEvery > 0 && i % snapshotEvery == 0) {
+							failed = job.snapshot(workers, i)
+						}
+						if isCheckpoint && len(failed) == 0 {
+							if err := job.writeCheckpoint(); err != nil {
+								fmt.Println("Error writing checkpoint:", err)
+							}
+						}
+					}
+				} else {
+					//the final world lives on the workers, so losing one now still means going back to the snapshot
+					var world [][]byte
+					world, failed = job.gatherWorld(workers)
+					res.World = job.output(world)
+					if len(failed) == 0 {
+						res.Alive, _ = job.getAliveCells(workers)
+						res.Turns = i //counted from the start of the job, even if we picked it up part way through
+						if period > 0 {
+							//the world is the same as it would have been after every turn, so finish as if we ran them
+							if job.Turns > i {
+								job.pushFrame(stubs.Frame{Turn: job.Turns, Skipped: job.Turns - i - 1, Jumped: true})
+							}
+							res.Turns = job.Turns
+							res.Period, res.FirstTurn = period, firstTurn
+						}
+						res.Frames = job.takeFrames()
+						finished = true
+					}
 				}
 
+				if len(failed) > 0 {
+					workers, i, err = job.recoverWorkers(workers, failed)
+					if err != nil {
+						job.TurnsMut.Unlock()
+						failJob(err.Error())
+						err = nil
+						b.parkJob(job)
+						return
+					}
 
-
-				//gather the work piecewise
-				for worker := 0; worker < b.Threads; worker++ {
-					turnRes := <-out
-					turnResponses[turnRes.ID] = *turnRes
+					job.OnTurn = i
+					job.setAlive(countAlive(job.getCurrentWorld(), job.Params.Rule.OrConway()), i)
+					job.Bounds = job.pack(job.getCurrentWorld()).Bounds()
+					job.restartSeen(job.getCurrentWorld(), i)
+					//the controller has drawn turns we've now lost, so start its picture again
+					job.pushFrame(fullFrame(job.getCurrentWorld(), i))
 				}
+		}
+		job.TurnsMut.Unlock()
+	}
 
+	//whoever stopped us early has already collected the world
+	if exitLoop {
+		res.Stopped = true
+		return
+	}
 
-				rowNum := 0
-				
-				b.WorldsMut.Lock()
+	//a finished job has nothing left to resume
+	removeCheckpoint(job.ID)
+	b.removeJob(job.ID)
+	//wake the controller's watcher so it isn't left waiting on a job that's gone
+	select {
+	case job.FrameReady <- true:
+	default:
+	}
 
-				for responseId := 0; responseId < len(turnResponses); responseId++ {
-					strip := turnResponses[responseId].Strip 
-					for rowId := 0; rowId < len(strip); rowId++ {
-						b.setCurrentWorldRow(rowNum, strip[rowId])
-						rowNum++
-					}
-				}
+	//hand the workers back for the next job
+	releaseWorkers(b, workers)
 
-				b.WorldsMut.Unlock()
 
-				res.Turns++
-				//reconstruct the world to go again
+	return
+}
 
-				b.AliveMut.Lock()
-				b.AliveTurnMut.Lock()
-				b.Alive, b.AliveTurn = b.getAliveCells(workers)
-				b.AliveMut.Unlock()
-				b.AliveTurnMut.Unlock()
+//runs a job on the broker with HashLife, which can jump over as many turns as it likes at once
+//the jumps double each time, so the start of the job can still be watched turn by turn
+func (b *Broker) runHashLife(job *Job, universe *hashlife.Universe, res *stubs.NewClientResponse) {
+	rule := job.Params.Rule.OrConway()
+
+	job.TurnsMut.Lock()
+	i := job.OnTurn
+	world := job.getCurrentWorld()
+	job.SnapshotTurn = i
+	job.setAlive(countAlive(world, rule), i)
+	job.pushFrame(fullFrame(world, i))
+	job.TurnsMut.Unlock()
+
+	jump := 1
+	for {
+		job.TurnsMut.Lock()
+		if job.Paused {
+			job.TurnsMut.Unlock()
+			select {
+			case <-job.Resume:
+				continue
+			case <-job.Finish:
+				//whoever stopped us has already dealt with the world
+				res.Stopped = true
+				return
+			}
+		}
+		select {
+		case <-job.Finish:
+			job.TurnsMut.Unlock()
+			res.Stopped = true
+			return
+		default:
+		}
+		if i >= job.Turns {
+			job.TurnsMut.Unlock()
+			break
+		}
 
-				b.TurnsMut.Lock()
-				i++
-				b.OnTurn = i
-				b.TurnsMut.Unlock()
+		for jump > job.Turns - i { jump /= 2 }
+		next := universe.Step(world, jump)
+		frame := changedFrame(world, next, i+jump)
+		frame.Skipped, frame.Jumped = jump-1, jump > 1
+		world = next
+		i += jump
+
+		//the world never leaves the broker, so every jump is as good as a snapshot
+		job.setCurrentWorld(world)
+		job.OnTurn = i
+		job.SnapshotTurn = i
+		job.setAlive(countAlive(world, rule), i)
+		job.pushFrame(frame)
+		if checkpointDir != "" && checkpointEvery > 0 && i / checkpointEvery != (i - jump) / checkpointEvery {
+			if err := job.writeCheckpoint(); err != nil {
+				fmt.Println("Error writing checkpoint:", err)
+			}
 		}
+		if hashLifeNodes > 0 && universe.Size() > hashLifeNodes {
+			universe.Reset()
+		}
+		job.TurnsMut.Unlock()
+		jump *= 2
 	}
 
-	res.World = b.getCurrentWorld()
-
-	b.AliveMut.Lock()
-	res.Alive, _ = b.getAliveCells(workers)
-	b.AliveMut.Unlock()
+	res.World = job.pack(world)
+	res.Alive = aliveCells(world, rule)
+	res.Frames = job.takeFrames()
+	res.Turns = i
 
-	//close the workers after we're finished
-	for _, worker := range workers {
-		worker.Connection.Close()
+	removeCheckpoint(job.ID)
+	b.removeJob(job.ID)
+	select {
+	case job.FrameReady <- true:
+	default:
 	}
-
-
-	return
 }
 
-func (b *Broker) ReportAlive(req stubs.EmptyRequest, res *stubs.AliveResponse) (err error){
+func (b *Broker) ReportAlive(req stubs.JobRequest, res *stubs.AliveResponse) (err error){
 	runningCalls.Add(1); defer runningCalls.Done()
-	b.AliveMut.Lock(); defer b.AliveMut.Unlock()
-	b.AliveTurnMut.Lock(); defer b.AliveTurnMut.Unlock()
-	res.Alive = b.Alive
-	res.OnTurn = b.AliveTurn
+
+	job, err := b.getJob(req.JobID)
+	if err != nil { return }
+
+	job.AliveMut.Lock(); defer job.AliveMut.Unlock()
+	res.CellsCount = job.AliveCount
+	res.OnTurn = job.AliveTurn
 	return
 }
 
@@ -451,21 +1902,45 @@ func (b *Broker) ReportAlive(req stubs.EmptyRequest, res *stubs.AliveResponse) (
 func main() {
 
 	pPort := flag.String("port", "8031", "Port to listen on")
-	pWorkerIPs := flag.String("worker_ips", "localhost", "Worker addresses for broker to connect to, enter as a comma separated list")
+	pWorkerIPs := flag.String("worker_ips", "", "Worker addresses to start the pool with, enter as a comma separated list. Workers started with -broker register themselves")
+	flag.IntVar(&workerThreads, "worker_threads", 0, "Goroutines each worker splits its strip between, 0 lets each worker use its own -threads")
+	flag.DurationVar(&turnTimeout, "turn_timeout", 30*time.Second, "How long a worker has to answer before it is treated as dead")
+	flag.DurationVar(&heartbeatEvery, "heartbeat", 1*time.Second, "How often every registered worker is pinged, 0 turns heartbeats off")
+	flag.StringVar(&checkpointDir, "checkpoint_dir", "", "Directory to write checkpoints to so jobs survive the broker restarting, leave empty to turn checkpoints off")
+	flag.IntVar(&checkpointEvery, "checkpoint_every", 1000, "Turns between checkpoints")
+	pPolicy := flag.String("schedule", "fifo", "Order queued jobs are given workers in: fifo, smallest or priority")
+	flag.DurationVar(&workerWait, "worker_wait", 10*time.Second, "How long a job waits for enough workers to register before it fails, workers take a few seconds to find a broker that has restarted")
+	flag.IntVar(&maxFrames, "max_frames", 250, "Frames kept for a controller that has fallen behind before they are merged into one, 0 keeps them all")
+	flag.IntVar(&hashLifeNodes, "hashlife_nodes", 4000000, "Squares and results a HashLife job keeps to jump over turns with before it forgets them all, 0 never forgets")
+	flag.IntVar(&maxWorld, "max_world", 16384, "Widest or tallest a world on an infinite plane can grow to before its job is stopped")
+	flag.IntVar(&snapshotEvery, "snapshot_every", 100, "Turns between copies of the world being pulled back from the workers, which is where the job restarts if a worker dies")
 
 	flag.Parse()
 
-	workerIPs = strings.Split(*pWorkerIPs, ",")
-	flag.Parse()
-	
+	policy = policies[*pPolicy]
+	if policy == nil {
+		handleError(fmt.Errorf("unknown scheduling policy %q", *pPolicy))
+	}
+	if checkpointDir != "" {
+		handleError(os.MkdirAll(checkpointDir, os.ModePerm))
+	}
 
-	rpc.Register(&Broker{})
+	broker := &Broker{}
+	for _, ip := range strings.Split(*pWorkerIPs, ",") {
+		if ip != "" { broker.addToPool(ip) }
+	}
+
+
+	rpc.Register(broker)
 	listener, err := net.Listen("tcp", ":"+*pPort) //listening for the client
 	fmt.Println("Listening on ", *pPort)
-	
+
 	handleError(err)
-	
+
 	go rpc.Accept(listener)
+	if heartbeatEvery > 0 {
+		go broker.heartbeat()
+	}
 
 	<-kill
 	//wait for the calls to terminate before I kill myself
@@ -473,4 +1948,4 @@ func main() {
 	err = listener.Close()
 
 	fmt.Println("Close broker")
-}
\ No newline at end of file
+}
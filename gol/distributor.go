@@ -1,20 +1,28 @@
 package gol
 
 import (
+	"context"
 	"fmt"
+	"image"
 	"net/rpc"
 	"sync"
 	"time"
 	"uk.ac.bris.cs/gameoflife/gol/stubs"
+	"uk.ac.bris.cs/gameoflife/picture"
+	"uk.ac.bris.cs/gameoflife/util"
 )
 
 type distributorChannels struct {
-	events     chan<- Event
-	ioCommand  chan<- ioCommand
-	ioIdle     <-chan bool
-	ioFilename chan<- string
-	ioOutput   chan<- uint8
-	ioInput    <-chan uint8
+	events      chan<- Event
+	ioCommand   chan<- ioCommand
+	ioIdle      <-chan bool
+	ioFilename  chan<- string
+	ioSize      chan<- image.Point
+	ioOutput    chan<- uint8
+	ioInput     <-chan uint8
+	ioRule      <-chan util.Rule
+	ioRecording chan<- *picture.Recording
+	ioError     <-chan error
 }
 
 /*
@@ -25,89 +33,337 @@ Distributed part (2)
 // //constants
 const aliveCellsPollDelay = 2 * time.Second
 
-func sendWriteCommand(p Params, c distributorChannels, currentTurn int, currentWorld [][]byte) {
-	filename := fmt.Sprintf("%vx%vx%v", p.ImageWidth, p.ImageHeight, currentTurn)
+//the controller's connection to the broker
+type brokerClient struct {
+	*rpc.Client
+	callTimeout time.Duration
+}
+
+//calls the broker, giving up once the call timeout has passed if there is one
+func (b brokerClient) call(method string, req interface{}, res interface{}) error {
+	call := b.Go(method, req, res, make(chan *rpc.Call, 1))
+
+	var timeout <-chan time.Time
+	if b.callTimeout > 0 {
+		timeout = time.After(b.callTimeout)
+	}
+
+	select {
+	case <-call.Done:
+		return call.Error
+	case <-timeout:
+		return fmt.Errorf("broker took longer than %v to answer %v", b.callTimeout, method)
+	}
+}
+
+//a world on an infinite plane comes back cropped to whatever isn't dead, so it can be any size
+func sendWriteCommand(p Params, c distributorChannels, currentTurn int, currentWorld util.Packed) error {
+	if !p.Topology.Grows() && (currentWorld.Height != p.ImageHeight || currentWorld.Width != p.ImageWidth) {
+		return fmt.Errorf("can't write a %vx%v world to a %vx%v image", currentWorld.Width, currentWorld.Height, p.ImageWidth, p.ImageHeight)
+	}
+
+	filename, err := p.OutputName(currentWorld.Width, currentWorld.Height, currentTurn)
+	if err != nil {
+		return err
+	}
 	c.ioCommand <- ioOutput
 	c.ioFilename <- filename
+	c.ioSize <- image.Point{X: currentWorld.Width, Y: currentWorld.Height}
+
+	for y := 0; y < currentWorld.Height; y++ {
+		for x := 0; x < currentWorld.Width; x++ {
+			c.ioOutput <- currentWorld.Get(x, y)
+		}
+	}
+
+	//the io goroutine tells us once the file is written, or why it couldn't be
+	if err := <-c.ioError; err != nil {
+		return fmt.Errorf("writing %v: %w", filename, err)
+	}
+
+	c.events <- ImageOutputComplete{CompletedTurns: currentTurn, Filename: filename}
+	return nil
+}
+
+//writes the view's recording out through the io goroutine, named after the turn the run got to
+func sendRecording(p Params, c distributorChannels, v *view, currentTurn int) error {
+	if v.recording == nil || v.recording.Frames() == 0 {
+		return nil
+	}
+	filename, err := p.OutputName(len(v.board[0]), len(v.board), currentTurn)
+	if err != nil {
+		return err
+	}
+	c.ioCommand <- ioRecord
+	c.ioFilename <- filename
+	c.ioRecording <- v.recording
+	if err := <-c.ioError; err != nil {
+		return fmt.Errorf("writing the recording %v: %w", filename, err)
+	}
+	return nil
+}
+
+//loads the starting world through the io goroutine, along with the rule the file gives if it gives one
+func readWorld(p Params, c distributorChannels) ([][]byte, util.Rule, error) {
+	c.ioCommand <- ioInput //send the appropriate command...
+	filename := p.inputPath()
+
+	c.ioFilename <- filename //...then send to distributor channel
+
+	var rule util.Rule
+	select {
+	case rule = <-c.ioRule:
+	case err := <-c.ioError:
+		return nil, rule, fmt.Errorf("reading %v: %w", filename, err)
+	}
+
+	world := make([][]byte, p.ImageHeight)
 
 	for y := 0; y < p.ImageHeight; y++ {
+		world[y] = make([]byte, p.ImageWidth)
 		for x := 0; x < p.ImageWidth; x++ {
-			c.ioOutput <- currentWorld[y][x]
+			select {
+			case pixel := <-c.ioInput: //gets image in with the io.goroutine
+				world[y][x] = pixel
+			case err := <-c.ioError:
+				return nil, rule, fmt.Errorf("reading %v: %w", filename, err)
+			}
 		}
 	}
 
-	c.events <- ImageOutputComplete{CompletedTurns: currentTurn, Filename: filename}
+	if err := <-c.ioError; err != nil {
+		return nil, rule, fmt.Errorf("reading %v: %w", filename, err)
+	}
+	return world, rule, nil
 }
 
-func finishServer(client *rpc.Client, c distributorChannels){
+func finishServer(client brokerClient, jobID int) (*stubs.QuitWorldResponse, error) {
 	res := new(stubs.QuitWorldResponse)
-	err := client.Call(stubs.BrokerFinishHander, stubs.EmptyRequest{}, res)
+	err := client.call(stubs.BrokerFinishHander, stubs.JobRequest{JobID: jobID}, res)
 	if err != nil {
-		fmt.Printf("Error client couldn't Finish server %s\n", err)
+		return res, fmt.Errorf("finishing job %v: %w", jobID, err)
 	}
 
-	c.events <- FinalTurnComplete{CompletedTurns: res.OnTurn, Alive: res.Alive}
+	return res, nil
 }
 
-func kill(p Params, client *rpc.Client, c distributorChannels, done chan bool) {
+func kill(p Params, client brokerClient, c distributorChannels, jobID int) error {
 	res := new(stubs.KillBrokerResponse)
 
-	err := client.Call(stubs.KillBroker, stubs.EmptyRequest{}, res)
-	fmt.Println(err)
-	sendWriteCommand(p, c, res.OnTurn, res.World)
+	err := client.call(stubs.KillBroker, stubs.JobRequest{JobID: jobID}, res)
+	if err != nil {
+		return fmt.Errorf("killing the broker: %w", err)
+	}
+	if err := sendWriteCommand(p, c, res.OnTurn, res.World); err != nil {
+		return err
+	}
 	c.events <- FinalTurnComplete{CompletedTurns: res.OnTurn, Alive: res.Alive}
-	
-	
-	
+	return nil
 }
 
 var paused sync.Mutex
 
+//how long each turn of a recording is shown for, in hundredths of a second
+const recordingDelay = 8
+
+//what the controller has drawn so far, so the broker's frames can be turned into CellFlipped events
+//or CellStateChanged events when the rule has dying states
+type view struct {
+	board     [][]uint8
+	rule      util.Rule
+	turn      int
+	started   bool               //the first frame is the world we start from, which isn't a turn of its own
+	quiet     bool               //NoView was asked for, so the view is only drawn for the recording and sends no events
+	recording *picture.Recording //every Record'th turn drawn goes in here, nil when not recording
+	every     int
+}
+
+func newView(p Params) *view {
+	board := make([][]uint8, p.ImageHeight)
+	for y := range board {
+		board[y] = make([]uint8, p.ImageWidth)
+	}
+	v := &view{board: board, rule: p.Rule.OrConway(), quiet: p.NoView, every: p.Record}
+	if p.Record > 0 {
+		v.recording = picture.NewRecording(v.rule, p.Picture, recordingDelay)
+	}
+	return v
+}
+
+//whether the view has to be drawn at all
+func watching(p Params) bool {
+	return !p.NoView || p.Record > 0
+}
+
+//cells come in where they are in the broker's world, which is moved by origin from where they are in the view
+//a world that has grown past the view has cells that aren't in it at all
+func (v *view) place(cell util.Cell, origin util.Cell) (util.Cell, bool) {
+	cell = util.Cell{X: cell.X + origin.X, Y: cell.Y + origin.Y}
+	return cell, cell.Y >= 0 && cell.Y < len(v.board) && cell.X >= 0 && cell.X < len(v.board[cell.Y])
+}
+
+func (v *view) set(c distributorChannels, turn int, cell util.Cell, level uint8) {
+	before := v.board[cell.Y][cell.X]
+	v.board[cell.Y][cell.X] = level
+	if v.quiet {
+		return
+	}
+	if v.rule.Generations() {
+		if before != level {
+			c.events <- CellStateChanged{CompletedTurns: turn, Cell: cell, Level: level}
+		}
+	} else if v.rule.Alive(before) != v.rule.Alive(level) {
+		c.events <- CellFlipped{CompletedTurns: turn, Cell: cell}
+	}
+}
+
+func (v *view) draw(c distributorChannels, frame stubs.Frame) {
+	if frame.Full {
+		//a full frame is every cell that isn't dead, so change whatever we have drawn that doesn't match
+		levels := make(map[util.Cell]uint8, len(frame.Flipped))
+		for i, cell := range frame.Flipped {
+			if cell, ok := v.place(cell, frame.Origin); ok {
+				levels[cell] = frame.Levels[i]
+			}
+		}
+		for y := range v.board {
+			for x := range v.board[y] {
+				cell := util.Cell{X: x, Y: y}
+				v.set(c, frame.Turn, cell, levels[cell])
+			}
+		}
+	} else {
+		for i, cell := range frame.Flipped {
+			if cell, ok := v.place(cell, frame.Origin); ok {
+				v.set(c, frame.Turn, cell, frame.Levels[i])
+			}
+		}
+	}
+
+	//skipped turns still complete, and a frame from a rollback after a worker failed doesn't complete any
+	//turns jumped over were never there to complete, so only the one jumped to does
+	if v.started && frame.Jumped && frame.Turn > v.turn && !v.quiet {
+		c.events <- TurnComplete{CompletedTurns: frame.Turn}
+	}
+	for turn := v.turn + 1; v.started && !frame.Jumped && turn <= frame.Turn && !v.quiet; turn++ {
+		c.events <- TurnComplete{CompletedTurns: turn}
+	}
+	//a frame that skipped or jumped past a turn to record stands in for it
+	if v.recording != nil && (!v.started || frame.Turn > v.turn && frame.Turn/v.every != v.turn/v.every) {
+		v.recording.Add(v.board)
+	}
+	if !v.started || frame.Turn > v.turn {
+		v.turn = frame.Turn
+	}
+	v.started = true
+}
+
+//keeps drawing whatever frames the broker has for us until the distributor stops us
+func watch(c distributorChannels, broker brokerClient, v *view, stop <-chan bool, jobID int) {
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		//the broker holds on to this call until it has a frame, so it is left out of the call timeout
+		res := new(stubs.FramesResponse)
+		if err := broker.Call(stubs.WatchHandler, stubs.JobRequest{JobID: jobID}, res); err != nil {
+			return
+		}
+		for _, frame := range res.Frames {
+			v.draw(c, frame)
+		}
+	}
+}
+
 //we only ever need write to events, and read from turns
-func ticks(c distributorChannels, broker *rpc.Client, done <-chan bool) {
+func ticks(c distributorChannels, broker brokerClient, done <-chan bool, jobID int) {
 	//newRound :=
 	ticker := time.NewTicker(aliveCellsPollDelay)
+	defer ticker.Stop()
+	position := 0
 	for {
 		select {
 		case <-done:
 			return
 		case <-ticker.C:
-			req := stubs.EmptyRequest{}
+			//there's nothing alive to count until the broker has found us some workers
+			queueRes := new(stubs.QueueResponse)
+			broker.call(stubs.QueueHandler, stubs.JobRequest{JobID: jobID}, queueRes)
+			if queueRes.Position > 0 {
+				if queueRes.Position != position {
+					c.events <- JobQueued{CompletedTurns: queueRes.OnTurn, Position: queueRes.Position, Length: queueRes.Length}
+				}
+				position = queueRes.Position
+				continue
+			}
+			position = 0
+
+			req := stubs.JobRequest{JobID: jobID}
 			res := new(stubs.AliveResponse)
 
-			broker.Call(stubs.BrokerAliveHandler, req, res)
-			c.events <- AliveCellsCount{CompletedTurns: res.OnTurn, CellsCount: len(res.Alive)}
+			//the job is gone once it has finished, which isn't worth reporting a count of nothing for
+			if err := broker.call(stubs.BrokerAliveHandler, req, res); err != nil {
+				continue
+			}
+			c.events <- AliveCellsCount{CompletedTurns: res.OnTurn, CellsCount: res.CellsCount}
 		}
 	}
 }
 
-func handleKeyPresses(p Params, c distributorChannels, client *rpc.Client, keyPresses <-chan rune, killServer chan<- bool, done chan bool) {
+//stopping is sent before q or k reach the broker, so the distributor knows its job ending early was us
+//stopped then gets whatever went wrong once the final state has been reported
+func handleKeyPresses(p Params, c distributorChannels, client brokerClient, keyPresses <-chan rune, stopping chan<- bool, stopped chan<- error, done <-chan bool, jobID int) {
+	//anything going wrong leaves the job on the broker to be continued, the same as q
+	fail := func(err error) {
+		stopping <- true
+		finishServer(client, jobID)
+		stopped <- err
+	}
+
 	isPaused := false
 	for {
-		k := <-keyPresses
+		var k rune
+		select {
+		case <-done:
+			return
+		case k = <-keyPresses:
+		}
+
 		switch k {
 		case 's':
 			//request current state through stubs package
 			//write the pgm out
-			req := stubs.EmptyRequest{}
+			req := stubs.JobRequest{JobID: jobID}
 			res := new(stubs.WorldResponse)
 
-			remoteDone := make(chan *rpc.Call, 1)
-			call := client.Go(stubs.SaveWorldHandler, req, res, remoteDone)
-			<-call.Done
+			if err := client.call(stubs.SaveWorldHandler, req, res); err != nil {
+				fail(fmt.Errorf("saving the world: %w", err))
+				return
+			}
 			fmt.Println("Generating PGM")
-			sendWriteCommand(p, c, res.OnTurn, res.World)
+			if err := sendWriteCommand(p, c, res.OnTurn, res.World); err != nil {
+				fail(err)
+				return
+			}
 			fmt.Println("Generated PGM")
 		case 'q':
 			fmt.Println("Closing the controller client program")
 			//leave the server running
-			finishServer(client, c)
+			stopping <- true
+			res, err := finishServer(client, jobID)
+			if err == nil {
+				c.events <- FinalTurnComplete{CompletedTurns: res.OnTurn, Alive: res.Alive}
+			}
+			stopped <- err
 			return
 		case 'k':
 			//request closure of server through stubs package
 			fmt.Println("Closing all components of the distributed system")
-			kill(p, client, c, done)
-			killServer <- true
+			stopping <- true
+			stopped <- kill(p, client, c, jobID)
 			return
 		case 'p':
 			//request pausing of aws node through stubs package
@@ -115,20 +371,25 @@ func handleKeyPresses(p Params, c distributorChannels, client *rpc.Client, keyPr
 			//once p is pressed again resume processing through requesting from stubs
 			if(!isPaused){
 				paused.Lock()
-				donePause := make(chan *rpc.Call, 1)
 				pauseRes := new(stubs.PauseResponse)
-				doPause := client.Go(stubs.BrokerPauseHandler, stubs.PauseRequest{Pause: true}, pauseRes, donePause)
-				<-doPause.Done
+				err := client.call(stubs.BrokerPauseHandler, stubs.PauseRequest{JobID: jobID, Pause: true}, pauseRes)
+				if err != nil {
+					paused.Unlock()
+					fail(fmt.Errorf("pausing: %w", err))
+					return
+				}
 				isPaused = true
 				c.events <-StateChange{CompletedTurns: pauseRes.Turns, NewState: Paused}
 			}else{
-				donePause := make(chan *rpc.Call, 1)
 				pauseRes := new(stubs.PauseResponse)
-				doPause := client.Go(stubs.BrokerPauseHandler, stubs.PauseRequest{Pause: false}, pauseRes, donePause)
-				<-doPause.Done
+				err := client.call(stubs.BrokerPauseHandler, stubs.PauseRequest{JobID: jobID, Pause: false}, pauseRes)
 				isPaused = false
-				c.events <-StateChange{CompletedTurns: pauseRes.Turns, NewState: Executing}
 				paused.Unlock()
+				if err != nil {
+					fail(fmt.Errorf("resuming: %w", err))
+					return
+				}
+				c.events <-StateChange{CompletedTurns: pauseRes.Turns, NewState: Executing}
 			}
 
 		default:
@@ -137,66 +398,132 @@ func handleKeyPresses(p Params, c distributorChannels, client *rpc.Client, keyPr
 	}
 }
 
-func safeClose(c distributorChannels, done chan bool) {
+func safeClose(c distributorChannels, done chan bool, running *sync.WaitGroup) {
 	// Make sure that the Io has finished any output before exiting.
 	c.ioCommand <- ioCheckIdle
 	<-c.ioIdle
 
-	
-	//
-	done <- true
+
+	//stop the tickers and key presses, and wait for them so nothing is sent on a closed channel
+	close(done)
+	running.Wait()
 	// Close the channel to stop the SDL goroutine gracefully. Removing may cause deadlock.
 	close(c.events)
 }
 
 // distributor divides the work between workers and interacts with other goroutines.
-func distributor(p Params, c distributorChannels, keyPresses <-chan rune, client *rpc.Client, cont bool) {
-	// TODO: Create a 2D slice to store the world.
-
-	c.ioCommand <- ioInput //send the appropriate command... (jump ln155)
-	filename := fmt.Sprintf("%vx%v", p.ImageHeight, p.ImageWidth)
-
-	c.ioFilename <- filename //...then send to distributor channel
+// Whatever goes wrong is sent as a RunError before the events channel is closed, as well as being returned.
+func distributor(ctx context.Context, p Params, c distributorChannels, keyPresses <-chan rune, client brokerClient) (err error) {
+	done := make(chan bool)
+	var running sync.WaitGroup
+	defer func() {
+		if err != nil {
+			c.events <- RunError{Err: err}
+		}
+		safeClose(c, done, &running)
+	}()
 
-	world := make([][]byte, p.ImageHeight)
+	world, rule, err := readWorld(p, c)
+	if err != nil {
+		return err
+	}
+	//a pattern that comes with its own rule runs under it unless we were given one
+	if p.Rule.IsZero() {
+		p.Rule = rule
+	}
+	if err = ctx.Err(); err != nil {
+		return err
+	}
 
+	params := stubs.Params{Turns: p.Turns, Threads: p.Threads, ImageWidth: p.ImageWidth, ImageHeight: p.ImageHeight, Rule: p.Rule, Topology: p.Topology, Engine: p.Engine, MaxPeriod: p.MaxPeriod}
 
-	for y := 0; y < p.ImageHeight; y++ {
-		world[y] = make([]byte, p.ImageWidth)
-		for x := 0; x < p.ImageWidth; x++ {
-			pixel := <-c.ioInput //gets image in with the io.goroutine
-			world[y][x] = pixel
+	//the broker may be running other people's jobs, so everything we ask it from here on is about our job id
+	jobRes := new(stubs.JobResponse)
+	err = client.call(stubs.CreateJobHandler, stubs.NewJobRequest{Params: params, Continue: p.Continue, JobID: p.Job, Priority: p.Priority, Watch: watching(p)}, jobRes)
+	if err != nil {
+		return fmt.Errorf("creating a job on the broker: %w", err)
+	}
+	jobID := jobRes.JobID
+	c.events <- JobStarted{JobID: jobID, Continuing: jobRes.Continuing}
+
+	stopping := make(chan bool, 1)
+	stopped := make(chan error, 1)
+	running.Add(2)
+	go func() {
+		defer running.Done()
+		handleKeyPresses(p, c, client, keyPresses, stopping, stopped, done, jobID)
+	}()
+	go func() {
+		defer running.Done()
+		ticks(c, client, done, jobID)
+	}()
+
+	v := newView(p)
+	stopWatching := make(chan bool)
+	watcherDone := make(chan bool)
+	go func() {
+		defer close(watcherDone)
+		if watching(p) {
+			watch(c, client, v, stopWatching, jobID)
 		}
+	}()
+	//the last frames come back with the job, and have to be drawn after anything the watcher is still drawing
+	stopWatch := func() {
+		close(stopWatching)
+		<-watcherDone
 	}
 
-	killServer := make(chan bool, 1)
-	done := make(chan bool)
-	go handleKeyPresses(p, c, client, keyPresses, killServer, done)
 
-	
-	go ticks(c, client, done)
+	brokerReq := stubs.NewClientRequest{JobID: jobID, World: util.Pack(world, params.Rule.Generations()), Params: params}
+	brokerRes := new(stubs.NewClientResponse)
 
+	call := client.Go(stubs.ClientHandler, brokerReq, brokerRes, make(chan *rpc.Call, 1))
+	select {
+	case <-call.Done:
+	case <-ctx.Done():
+		//leave the job on the broker so it can be continued, the same as pressing q
+		finishServer(client, jobID)
+		<-call.Done
+		stopWatch()
+		return ctx.Err()
+	}
+	stopWatch()
+	if call.Error != nil {
+		return fmt.Errorf("running job %v: %w", jobID, call.Error)
+	}
 
-	params := stubs.Params{Turns: p.Turns, Threads: p.Threads, ImageWidth: p.ImageWidth, ImageHeight: p.ImageHeight}
+	if brokerRes.Stopped {
+		select {
+		case <-stopping:
+			//q or k, the key press handler reports the final state itself, the recording goes up to what we saw
+			if err := <-stopped; err != nil {
+				return err
+			}
+			return sendRecording(p, c, v, v.turn)
+		default:
+			return fmt.Errorf("job %v was stopped by the broker", jobID)
+		}
+	}
+	if brokerRes.Turns < 0 {
+		return fmt.Errorf("broker couldn't run job %v: %v", jobID, brokerRes.Issue)
+	}
 
-	brokerReq := stubs.NewClientRequest{World: world, Params: params, Continue: cont}
-	brokerRes := new(stubs.NewClientResponse)
+	for _, frame := range brokerRes.Frames {
+		v.draw(c, frame)
+	}
 
-	client.Call(stubs.ClientHandler, brokerReq, brokerRes)
-	// TODO: Report the final state using FinalTurnCompleteEvent.
+	if err := sendRecording(p, c, v, brokerRes.Turns); err != nil {
+		return err
+	}
 
-	select {
-		case <-killServer:
-			safeClose(c, done)
-		default:
-			final := FinalTurnComplete{CompletedTurns: brokerRes.Turns, Alive: brokerRes.Alive}
-	
-			c.events <- final //sending event down events channel
-			fmt.Println("Ready to save")
-			sendWriteCommand(p, c, brokerRes.Turns, brokerRes.World)
-		
-		
-			safeClose(c, done)
+	if brokerRes.Period > 0 {
+		c.events <- StabilisedDetected{CompletedTurns: brokerRes.Turns, Period: brokerRes.Period, FirstTurn: brokerRes.FirstTurn}
 	}
 
+	// TODO: Report the final state using FinalTurnCompleteEvent.
+	final := FinalTurnComplete{CompletedTurns: brokerRes.Turns, Alive: brokerRes.Alive}
+
+	c.events <- final //sending event down events channel
+	fmt.Println("Ready to save")
+	return sendWriteCommand(p, c, brokerRes.Turns, brokerRes.World)
 }
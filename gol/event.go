@@ -53,6 +53,15 @@ type CellFlipped struct { // implements Event
 	Cell           util.Cell
 }
 
+// CellStateChanged is an Event notifying the GUI about a cell changing state under a Generations rule.
+// It is sent in place of CellFlipped when cells have dying states between alive and dead.
+// Level is the cell's new grey level, the same as it would be written to the pgm: 255 is alive, 0 is dead.
+type CellStateChanged struct { // implements Event
+	CompletedTurns int
+	Cell           util.Cell
+	Level          uint8
+}
+
 // TurnComplete is an Event notifying the GUI about turn completion.
 // SDL will render a frame when this event is sent.
 // All CellFlipped events must be sent *before* TurnComplete.
@@ -60,6 +69,38 @@ type TurnComplete struct { // implements Event
 	CompletedTurns int
 }
 
+// JobQueued is an Event notifying the user that the broker has no free workers for the job yet.
+// This Event is sent every time the job's place in the broker's queue changes.
+type JobQueued struct { // implements Event
+	CompletedTurns int
+	Position       int // 1 is next to be given workers
+	Length         int
+}
+
+// JobStarted is an Event notifying the user which of the broker's jobs is theirs, which Params.Job can pick back up.
+// It is sent once the broker has taken the job on, before any other Event about it.
+type JobStarted struct { // implements Event
+	CompletedTurns int
+	JobID          int
+	Continuing     bool // the broker found a job stopped earlier to carry on with
+}
+
+// RunError is an Event notifying the user that the Game of Life couldn't carry on.
+// It is sent in place of FinalTurnComplete, as the last Event before the events channel is closed.
+type RunError struct { // implements Event
+	CompletedTurns int
+	Err            error
+}
+
+// StabilisedDetected is an Event notifying the user that the world settled into still lifes and oscillators,
+// so the broker stopped stepping it. The world repeats every Period turns from FirstTurn on.
+// It is sent just before FinalTurnComplete, whose world is still the one the run would have ended on.
+type StabilisedDetected struct { // implements Event
+	CompletedTurns int
+	Period         int // 1 for a world that stopped changing
+	FirstTurn      int
+}
+
 // FinalTurnComplete is an Event notifying the testing framework about the new world state after execution finished.
 // The data included with this Event is used directly by the tests.
 // SDL closes the window when this Event is sent.
@@ -115,6 +156,14 @@ func (event CellFlipped) GetCompletedTurns() int {
 	return event.CompletedTurns
 }
 
+func (event CellStateChanged) String() string {
+	return fmt.Sprintf("")
+}
+
+func (event CellStateChanged) GetCompletedTurns() int {
+	return event.CompletedTurns
+}
+
 func (event TurnComplete) String() string {
 	return fmt.Sprintf("")
 }
@@ -123,6 +172,41 @@ func (event TurnComplete) GetCompletedTurns() int {
 	return event.CompletedTurns
 }
 
+func (event JobQueued) String() string {
+	return fmt.Sprintf("Queued %v of %v", event.Position, event.Length)
+}
+
+func (event JobQueued) GetCompletedTurns() int {
+	return event.CompletedTurns
+}
+
+func (event JobStarted) String() string {
+	if event.Continuing {
+		return fmt.Sprintf("Continuing job %v", event.JobID)
+	}
+	return fmt.Sprintf("Running job %v", event.JobID)
+}
+
+func (event JobStarted) GetCompletedTurns() int {
+	return event.CompletedTurns
+}
+
+func (event RunError) String() string {
+	return fmt.Sprintf("Error: %v", event.Err)
+}
+
+func (event RunError) GetCompletedTurns() int {
+	return event.CompletedTurns
+}
+
+func (event StabilisedDetected) String() string {
+	return fmt.Sprintf("Repeating every %v turns since turn %v", event.Period, event.FirstTurn)
+}
+
+func (event StabilisedDetected) GetCompletedTurns() int {
+	return event.CompletedTurns
+}
+
 func (event FinalTurnComplete) String() string {
 	return fmt.Sprintf("")
 }
@@ -1,20 +1,200 @@
 package gol
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"image"
+	"net"
 	"net/rpc"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/pattern"
+	"uk.ac.bris.cs/gameoflife/picture"
+	"uk.ac.bris.cs/gameoflife/util"
 )
 
 // Params provides the details of how to run the Game of Life and which image to load.
 type Params struct {
 	Turns       int
 	Threads     int
-	ImageWidth  int
-	ImageHeight int
+	ImageWidth  int            // 0 to take it from the input file, see WithSize
+	ImageHeight int            // 0 to take it from the input file, see WithSize
+	Rule        util.Rule      // life-like rule to run, Conway's B3/S23 when left as the zero Rule
+	Topology    util.Topology  // how the edges of the world join up, a torus when left as the zero Topology
+	Engine      util.Engine    // what works out the turns, strips split between the broker's workers when left as the zero Engine
+	Format      pattern.Format // what the world is read and written as, whichever format the file is found in when left as Auto
+	Input       string         // pattern file to start from, images/WxH in whichever format is there when left empty
+	OutputDir   string         // where worlds, pictures and recordings are written, out when left empty
+	Filename    string         // what they're called without the extension, DefaultFilename when left empty, see OutputName for the placeholders
+	PNG         bool           // write a PNG picture of the world next to every world file written
+	Record      int            // add every Record'th turn to an animated GIF of the run, written to OutputDir when the run ends, 0 doesn't record
+	Picture     picture.Style  // how cells are drawn in PNGs and GIFs, a pixel a cell in white on black when left as the zero Style
+	MaxPeriod   int            // longest cycle the broker looks for so it can end the job early once the world repeats, 0 (or HashLife) doesn't look
+	Job         int            // job to pick back up when continuing, 0 for the most recent one of the same size
+	Priority    int            // higher goes first when the broker schedules by priority
+	Continue    bool           // pick up a job stopped earlier rather than starting a new one
+	NoView      bool           // don't send CellFlipped and TurnComplete events, which saves the broker and workers finding them
+	Broker      BrokerOptions
+}
+
+// DefaultFilename is what files written out are called unless Params says otherwise, as in 512x512x100.
+const DefaultFilename = "{width}x{height}x{turn}"
+
+// WithSize fills in a width or height left as 0 with the size of the input file, reading only as much
+// of it as it takes to find out.
+func (p Params) WithSize() (Params, error) {
+	if p.ImageWidth > 0 && p.ImageHeight > 0 {
+		return p, nil
+	}
+	if p.Input == "" {
+		return p, errors.New("no size given and no input file to take it from")
+	}
+
+	path, format, err := findInput(p.Input, p.Format)
+	if err != nil {
+		return p, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return p, err
+	}
+	defer file.Close()
+
+	width, height, err := pattern.Size(file, format)
+	if err != nil {
+		return p, fmt.Errorf("%s: %w", path, err)
+	}
+	if p.ImageWidth <= 0 {
+		p.ImageWidth = width
+	}
+	if p.ImageHeight <= 0 {
+		p.ImageHeight = height
+	}
+	return p, nil
+}
+
+// OutputName is what a file written for a width by height world on a turn is called, without its extension.
+// The Filename template's placeholders are {width}, {height}, {turn}, {rule}, with / written as _,
+// {timestamp}, when the file is written, as in 20060102-150405, and {input}, the input file's name
+// without its directory or extension.
+func (p Params) OutputName(width, height, turn int) (string, error) {
+	template := p.Filename
+	if template == "" {
+		template = DefaultFilename
+	}
+	input := p.inputPath()
+	values := map[string]string{
+		"width":     strconv.Itoa(width),
+		"height":    strconv.Itoa(height),
+		"turn":      strconv.Itoa(turn),
+		"rule":      strings.Replace(p.Rule.OrConway().String(), "/", "_", -1),
+		"timestamp": time.Now().Format("20060102-150405"),
+		"input":     strings.TrimSuffix(filepath.Base(input), filepath.Ext(input)),
+	}
+
+	var name strings.Builder
+	for {
+		open := strings.Index(template, "{")
+		if open < 0 {
+			break
+		}
+		end := strings.Index(template[open:], "}")
+		if end < 0 {
+			return "", fmt.Errorf("filename %q has a { with no } after it", p.Filename)
+		}
+		value, ok := values[template[open+1:open+end]]
+		if !ok {
+			return "", fmt.Errorf("filename %q has an unknown placeholder %v, expected {width}, {height}, {turn}, {rule}, {timestamp} or {input}",
+				p.Filename, template[open:open+end+1])
+		}
+		name.WriteString(template[:open])
+		name.WriteString(value)
+		template = template[open+end+1:]
+	}
+	name.WriteString(template)
+	return name.String(), nil
+}
+
+// inputPath is the file the world is read from, which can be missing its extension.
+func (p Params) inputPath() string {
+	if p.Input != "" {
+		return p.Input
+	}
+	return filepath.Join("images", fmt.Sprintf("%vx%v", p.ImageWidth, p.ImageHeight))
+}
+
+// outputDir is the directory files are written to.
+func (p Params) outputDir() string {
+	if p.OutputDir != "" {
+		return p.OutputDir
+	}
+	return "out"
+}
+
+// BrokerOptions says where the broker is and how hard to try to reach it.
+// Anything left as zero is taken from the matching environment variable, and failing that the default.
+type BrokerOptions struct {
+	Address     string        // host:port, $GOL_BROKER, defaults to localhost:8031
+	DialTimeout time.Duration // $GOL_DIAL_TIMEOUT, defaults to waiting as long as the OS does
+	Retries     int           // dial attempts after the first one fails, $GOL_RETRIES, defaults to 0
+	Backoff     time.Duration // wait before the first retry, doubled after each one, $GOL_BACKOFF, defaults to 500ms
+	CallTimeout time.Duration // deadline on every call to the broker except running the job and waiting for frames, $GOL_CALL_TIMEOUT, defaults to none
+}
+
+// withDefaults fills in whatever hasn't been set from the environment, then from the defaults.
+func (o BrokerOptions) withDefaults() (BrokerOptions, error) {
+	var err error
+	duration := func(d *time.Duration, env string) {
+		if value := os.Getenv(env); *d == 0 && value != "" && err == nil {
+			*d, err = time.ParseDuration(value)
+		}
+	}
+
+	if o.Address == "" {
+		o.Address = os.Getenv("GOL_BROKER")
+	}
+	if o.Address == "" {
+		o.Address = "localhost:8031"
+	}
+	if value := os.Getenv("GOL_RETRIES"); o.Retries == 0 && value != "" {
+		o.Retries, err = strconv.Atoi(value)
+	}
+	duration(&o.DialTimeout, "GOL_DIAL_TIMEOUT")
+	duration(&o.Backoff, "GOL_BACKOFF")
+	duration(&o.CallTimeout, "GOL_CALL_TIMEOUT")
+	if o.Backoff == 0 {
+		o.Backoff = 500 * time.Millisecond
+	}
+
+	if err != nil {
+		return o, fmt.Errorf("reading broker options from the environment: %w", err)
+	}
+	return o, nil
 }
 
 // Run starts the processing of Game of Life. It should initialise channels and goroutines.
+// Anything that goes wrong is sent down events as a RunError, see RunContext to get it back as an error instead.
 func Run(p Params, events chan<- Event, keyPresses <-chan rune, cont... bool) {
+	if len(cont) > 1 {
+		events <- RunError{Err: errors.New("no way to interpret value(s) of 'cont'")}
+		close(events)
+		return
+	} else if len(cont) == 1 {
+		p.Continue = cont[0]
+	}
+
+	_ = RunContext(context.Background(), p, events, keyPresses)
+}
+
+// RunContext is Run for programs that embed the controller. It returns whatever stopped the Game of Life from
+// finishing rather than panicking, after sending it down events as a RunError and closing events.
+// Cancelling ctx leaves the job on the broker to be continued later, the same as pressing q.
+func RunContext(ctx context.Context, p Params, events chan<- Event, keyPresses <-chan rune) error {
 	/*
 		inputs
 			p -> CL arguments
@@ -23,46 +203,94 @@ func Run(p Params, events chan<- Event, keyPresses <-chan rune, cont... bool) {
 
 	//	TODO: Put the missing channels in here.
 
+	//a filename that can't be filled in is better found out now than once the run is over
+	p, err := p.WithSize()
+	if err == nil {
+		_, err = p.OutputName(p.ImageWidth, p.ImageHeight, 0)
+	}
+	if err != nil {
+		events <- RunError{Err: err}
+		close(events)
+		return err
+	}
+
+	//adding rpc "server" to make call for work to ()
+	//dialled before the io goroutine starts, so nothing is left waiting on io if the broker can't be reached
+	options, err := p.Broker.withDefaults()
+	var client *rpc.Client
+	if err == nil {
+		client, err = dialBroker(ctx, options)
+	}
+	if err != nil {
+		events <- RunError{Err: err}
+		close(events)
+		return err
+	}
+	defer client.Close()
+
 	ioCommand := make(chan ioCommand)
 	ioIdle := make(chan bool)
 	ioFilename := make(chan string)
+	ioSize := make(chan image.Point)
 	ioOutput := make(chan uint8)
 	ioInput := make(chan uint8)
+	ioRule := make(chan util.Rule)
+	ioRecording := make(chan *picture.Recording)
+	ioError := make(chan error)
 
 	ioChannels := ioChannels{
-		command:  ioCommand,
-		idle:     ioIdle,
-		filename: ioFilename,
-		output:   ioOutput,
-		input:    ioInput,
+		command:   ioCommand,
+		idle:      ioIdle,
+		filename:  ioFilename,
+		size:      ioSize,
+		output:    ioOutput,
+		input:     ioInput,
+		rule:      ioRule,
+		recording: ioRecording,
+		err:       ioError,
 	}
 
 	//entrypoint of the io.go goroutine
 	go startIo(p, ioChannels) //where the io goroutine is started
+	//the distributor waits for io to be idle and for everything else that uses it before returning, so io can stop then
+	defer close(ioCommand)
 
 	distributorChannels := distributorChannels{
-		events:     events,
-		ioCommand:  ioCommand,
-		ioIdle:     ioIdle,
-		ioFilename: ioFilename,
-		ioOutput:   ioOutput,
-		ioInput:    ioInput,
-	}
-	server := "localhost:8031" //to dial the broker
-	//adding rpc "server" to make call for work to ()
-	client, err := rpc.Dial("tcp", server)
-
-	var carryOn bool
-	if len(cont) == 0 {
-		carryOn = false //default value
-	} else if len(cont) == 1 {
-		carryOn = cont[0]
-	}else{
-		err = errors.New("Error: no way to interpret value(s) of 'cont'")
+		events:      events,
+		ioCommand:   ioCommand,
+		ioIdle:      ioIdle,
+		ioFilename:  ioFilename,
+		ioSize:      ioSize,
+		ioOutput:    ioOutput,
+		ioInput:     ioInput,
+		ioRule:      ioRule,
+		ioRecording: ioRecording,
+		ioError:     ioError,
 	}
 
-	if(err != nil) { panic(err) } //rudimentary error handling
-	defer client.Close()
+	broker := brokerClient{Client: client, callTimeout: options.CallTimeout}
+	return distributor(ctx, p, distributorChannels, keyPresses, broker)
+}
+
+// dialBroker keeps trying the broker until it answers or we run out of retries, backing off a little more each time.
+func dialBroker(ctx context.Context, o BrokerOptions) (*rpc.Client, error) {
+	backoff := o.Backoff
+	for attempt := 0; ; attempt++ {
+		dialer := net.Dialer{Timeout: o.DialTimeout}
+		conn, err := dialer.DialContext(ctx, "tcp", o.Address)
+		if err == nil {
+			return rpc.NewClient(conn), nil
+		}
+		if attempt >= o.Retries || ctx.Err() != nil {
+			return nil, fmt.Errorf("dialling the broker at %v: %w", o.Address, err)
+		}
 
-	distributor(p, distributorChannels, keyPresses, client, carryOn)
+		fmt.Printf("Couldn't reach the broker at %v, trying again in %v\n", o.Address, backoff)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
 }
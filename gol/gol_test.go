@@ -0,0 +1,42 @@
+package gol
+
+import (
+	"context"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// TestRunContextUnreachableBroker tests that a run which can't reach the broker reports it and leaves nothing running.
+func TestRunContextUnreachableBroker(t *testing.T) {
+	// a port that was free a moment ago, so dialling it is refused
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	before := runtime.NumGoroutine()
+	for i := 0; i < 10; i++ {
+		events := make(chan Event, 1)
+		p := Params{ImageWidth: 16, ImageHeight: 16, Broker: BrokerOptions{Address: address, Retries: -1}} // -1 so $GOL_RETRIES can't add any
+		if err := RunContext(context.Background(), p, events, nil); err == nil {
+			t.Fatal("expected an error")
+		}
+		if event, ok := <-events; !ok {
+			t.Fatal("no RunError before the events channel was closed")
+		} else if _, ok := event.(RunError); !ok {
+			t.Fatalf("got %v, expected a RunError", event)
+		}
+	}
+
+	// give anything stopping a moment to finish
+	for deadline := time.Now().Add(time.Second); runtime.NumGoroutine() > before && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("%d goroutines before the runs and %d after", before, after)
+	}
+}
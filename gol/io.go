@@ -2,10 +2,12 @@ package gol
 
 import (
 	"fmt"
-	"io/ioutil"
+	"image"
 	"os"
-	"strconv"
-	"strings"
+	"path/filepath"
+
+	"uk.ac.bris.cs/gameoflife/pattern"
+	"uk.ac.bris.cs/gameoflife/picture"
 	"uk.ac.bris.cs/gameoflife/util"
 )
 
@@ -14,14 +16,20 @@ type ioChannels struct {
 	idle    chan<- bool
 
 	filename <-chan string
+	size     <-chan image.Point // width and height of the image about to be written, sent after its filename
 	output   <-chan uint8
 	input    chan<- uint8
+	rule     chan<- util.Rule // the rule the file being read gives, sent before its cells
+	err      chan<- error     // the result of every input and output command, nil if it went fine
+
+	recording <-chan *picture.Recording // a run's recording to write, sent after its filename
 }
 
 // ioState is the internal ioState of the io goroutine.
 type ioState struct {
 	params   Params
 	channels ioChannels
+	format   pattern.Format // what the world was read in, and so what it's written in unless params says otherwise
 }
 
 // ioCommand allows requesting behaviour from the io (pgm) goroutine.
@@ -32,115 +40,182 @@ type ioCommand uint8
 //		ioOutput 	= 0
 //		ioInput 	= 1
 //		ioCheckIdle = 2
+//		ioRecord    = 3
 const (
 	ioOutput ioCommand = iota
 	ioInput
 	ioCheckIdle
+	ioRecord
 )
 
-// writePgmImage receives an array of bytes and writes it to a pgm file.
-func (io *ioState) writePgmImage() {
-	_ = os.Mkdir("out", os.ModePerm)
-
+// writePattern receives an array of bytes and writes it to a pattern file, in the format Params asks
+// for or else the one the world was read in.
+// The distributor always sends the whole image, so it is read in full even if the file can't be written.
+// It is usually the size in params, but a world on an infinite plane is cropped to whatever isn't dead.
+func (io *ioState) writePattern() (ioError error) {
 	// Request a filename from the distributor.
-	filename := <-io.channels.filename //having called writePgmImage, we give it a file name
-
-	file, ioError := os.Create("out/" + filename + ".pgm")
-	util.Check(ioError)
-	defer file.Close()
-
-	_, _ = file.WriteString("P5\n")
-	//_, _ = file.WriteString("# PGM file writer by pnmmodules (https://github.com/owainkenwayucl/pnmmodules).\n")
-	_, _ = file.WriteString(strconv.Itoa(io.params.ImageWidth))
-	_, _ = file.WriteString(" ")
-	_, _ = file.WriteString(strconv.Itoa(io.params.ImageHeight))
-	_, _ = file.WriteString("\n")
-	_, _ = file.WriteString(strconv.Itoa(255))
-	_, _ = file.WriteString("\n")
+	filename := <-io.channels.filename //having called writePattern, we give it a file name
+	size := <-io.channels.size
 
-	world := make([][]byte, io.params.ImageHeight)
+	world := make([][]byte, size.Y)
 	for i := range world {
-		world[i] = make([]byte, io.params.ImageWidth)
+		world[i] = make([]byte, size.X)
 	}
 
-	for y := 0; y < io.params.ImageHeight; y++ {
-		for x := 0; x < io.params.ImageWidth; x++ {
-			val := <-io.channels.output //send write the image byte-by-byte
-			//if val != 0 {
-			//	fmt.Println(x, y)
-			//}
-			world[y][x] = val
+	for y := 0; y < size.Y; y++ {
+		for x := 0; x < size.X; x++ {
+			world[y][x] = <-io.channels.output //send write the image byte-by-byte
 		}
 	}
 
-	for y := 0; y < io.params.ImageHeight; y++ {
-		for x := 0; x < io.params.ImageWidth; x++ {
-			_, ioError = file.Write([]byte{world[y][x]})
-			util.Check(ioError)
+	format := io.params.Format
+	if format == pattern.Auto {
+		format = io.format
+	}
+	ioError = writeFile(io.params.outputDir(), filename+format.Extension(), func(file *os.File) error {
+		return pattern.Write(file, format, pattern.Pattern{Cells: world, Rule: io.params.Rule})
+	})
+	if ioError != nil {
+		return ioError
+	}
+	if io.params.PNG {
+		ioError = writeFile(io.params.outputDir(), filename+".png", func(file *os.File) error {
+			return picture.WritePNG(file, world, io.params.Rule, io.params.Picture)
+		})
+		if ioError != nil {
+			return ioError
 		}
 	}
 
-	ioError = file.Sync()
-	util.Check(ioError)
-
 	fmt.Println("File", filename, "output done!")
+	return nil
 }
 
-// readPgmImage opens a pgm file and sends its data as an array of bytes.
-func (io *ioState) readPgmImage() {
-
-	// Request a filename from the distributor.
+// writeRecording writes the recording of a run out as an animated GIF.
+func (io *ioState) writeRecording() error {
 	filename := <-io.channels.filename
+	recording := <-io.channels.recording
 
-	data, ioError := ioutil.ReadFile("images/" + filename + ".pgm")
-	util.Check(ioError)
+	ioError := writeFile(io.params.outputDir(), filename+".gif", func(file *os.File) error {
+		return recording.WriteGIF(file)
+	})
+	if ioError != nil {
+		return ioError
+	}
+	fmt.Println("File", filename, "recording done!", recording.Frames(), "frames")
+	return nil
+}
 
-	fields := strings.Fields(string(data))
+// writeFile creates a file in dir, and any directories it's in that aren't there yet, and has write
+// fill it in, making sure it's on disk before it's closed.
+func writeFile(dir, filename string, write func(file *os.File) error) error {
+	path := filepath.Join(dir, filename)
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
 
-	if fields[0] != "P5" {
-		panic("Not a pgm file")
+	if err = write(file); err != nil {
+		return err
 	}
+	return file.Sync()
+}
+
+// readPattern opens a pattern file and sends its data as an array of bytes, the size in params with
+// anything smaller in the middle. The rule the file gives is sent first, the zero Rule if it doesn't give one.
+// The file's path can leave off its extension, see findInput.
+// Nothing is sent if the image can't be read, only the error.
+func (io *ioState) readPattern() error {
 
-	width, _ := strconv.Atoi(fields[1])
-	if width != io.params.ImageWidth {
-		panic("Incorrect width")
+	// Request a path from the distributor.
+	path, format, ioError := findInput(<-io.channels.filename, io.params.Format)
+	if ioError != nil {
+		return ioError
 	}
 
-	height, _ := strconv.Atoi(fields[2])
-	if height != io.params.ImageHeight {
-		panic("Incorrect height")
+	file, ioError := os.Open(path)
+	if ioError != nil {
+		return ioError
 	}
+	defer file.Close()
 
-	maxval, _ := strconv.Atoi(fields[3])
-	if maxval != 255 {
-		panic("Incorrect maxval/bit depth")
+	world, ioError := pattern.Read(file, format, io.params.Rule)
+	if ioError != nil {
+		return fmt.Errorf("%s: %w", path, ioError)
+	}
+	world, ioError = world.Place(io.params.ImageWidth, io.params.ImageHeight)
+	if ioError != nil {
+		return fmt.Errorf("%s: %w", path, ioError)
+	}
+	io.format = format
+	if io.params.Rule.IsZero() {
+		io.params.Rule = world.Rule
 	}
 
-	image := []byte(fields[4])
+	io.channels.rule <- world.Rule
+	for _, row := range world.Cells {
+		for _, b := range row {
+			io.channels.input <- b //wired up to the distributor byte by byte
+		}
+	}
 
-	for _, b := range image {
-		io.channels.input <- b //wired up to the distributor byte by byte
+	fmt.Println("File", path, "input done!")
+	return nil
+}
+
+// findInput works out which file to read and what format it's in. A file that's there is read as the
+// format given, or the one its extension says when the format is Auto. Otherwise the path is taken
+// to be missing its extension, and is the one with the format's extension added, or without a format
+// whichever of the formats' extensions is found first.
+func findInput(path string, format pattern.Format) (string, pattern.Format, error) {
+	if _, err := os.Stat(path); err == nil {
+		if format == pattern.Auto {
+			format = pattern.FormatOf(path)
+		}
+		if format == pattern.Auto {
+			return path, format, fmt.Errorf("%s: can't tell what format it's in from its extension, a format has to be given", path)
+		}
+		return path, format, nil
 	}
 
-	fmt.Println("File", filename, "input done!")
+	formats := pattern.Formats
+	if format != pattern.Auto {
+		formats = []pattern.Format{format}
+	}
+	for _, f := range formats {
+		if _, err := os.Stat(path + f.Extension()); err == nil {
+			return path + f.Extension(), f, nil
+		}
+	}
+	return path, format, fmt.Errorf("no %s file in any of the formats %v", path, formats)
 }
 
-// startIo should be the entrypoint of the io goroutine.
+// startIo should be the entrypoint of the io goroutine. It returns once the command channel is closed.
 func startIo(p Params, c ioChannels) {
 	io := ioState{
 		params:   p,
 		channels: c,
+		format:   pattern.PGM,
 	}
 
 	for {
 		select {
 		// Block and wait for requests from the distributor
-		case command := <-io.channels.command:
+		case command, ok := <-io.channels.command:
+			if !ok {
+				return
+			}
 			switch command { //three commands can be sent to the io
 			case ioInput: //loads image
-				io.readPgmImage()
+				io.channels.err <- io.readPattern()
 			case ioOutput: //write image
-				io.writePgmImage()
+				io.channels.err <- io.writePattern()
+			case ioRecord: //write a run's recording
+				io.channels.err <- io.writeRecording()
 			case ioCheckIdle: //checks if io.go is idle, this defends against exiting if we are still reading or still writing
 				io.channels.idle <- true //we can safely close the program
 			}
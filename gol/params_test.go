@@ -0,0 +1,120 @@
+package gol
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/pattern"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// TestOutputName tests the filename template's placeholders, and templates it can't fill in.
+func TestOutputName(t *testing.T) {
+	tests := []struct {
+		params   Params
+		expected string
+		bad      bool
+	}{
+		{params: Params{}, expected: "16x8x100"},
+		{params: Params{Filename: "{width}x{height}x{turn}"}, expected: "16x8x100"},
+		{params: Params{Filename: "run"}, expected: "run"},
+		{params: Params{Filename: "{rule}-{turn}"}, expected: "B3_S23-100"},
+		{params: Params{Filename: "{rule}", Rule: util.MustParseRule("B36/S23")}, expected: "B36_S23"},
+		{params: Params{Filename: "{input}-{turn}", Input: "patterns/glider.rle"}, expected: "glider-100"},
+		{params: Params{Filename: "{input}", ImageWidth: 16, ImageHeight: 8}, expected: "16x8"},
+		{params: Params{Filename: "{turn}}"}, expected: "100}"},
+		{params: Params{Filename: "{width"}, bad: true},
+		{params: Params{Filename: "{width}x{height"}, bad: true},
+		{params: Params{Filename: "{depth}"}, bad: true},
+		{params: Params{Filename: "{}"}, bad: true},
+		{params: Params{Filename: "{Turn}"}, bad: true},
+	}
+	for _, test := range tests {
+		t.Run(test.params.Filename, func(t *testing.T) {
+			name, err := test.params.OutputName(16, 8, 100)
+			if test.bad {
+				if err == nil {
+					t.Errorf("expected an error, got %q", name)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if name != test.expected {
+				t.Errorf("got %q, expected %q", name, test.expected)
+			}
+		})
+	}
+
+	// the timestamp changes, so only its shape can be checked
+	name, err := Params{Filename: "{timestamp}"}.OutputName(16, 8, 100)
+	if err != nil || len(name) != len("20060102-150405") || name[8] != '-' {
+		t.Errorf("got %q, %v for {timestamp}", name, err)
+	}
+}
+
+// TestWithSize tests taking the world's size from an input file in each format, with or without its extension.
+func TestWithSize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gol")
+	util.Check(err)
+	defer os.RemoveAll(dir)
+
+	cells := make([][]uint8, 12)
+	for y := range cells {
+		cells[y] = make([]uint8, 20)
+	}
+	// Life 1.06 only keeps the alive cells, so the corners have to be alive for it to keep the size
+	cells[0][0], cells[11][19] = 255, 255
+
+	for _, format := range pattern.Formats {
+		t.Run(format.String(), func(t *testing.T) {
+			path := filepath.Join(dir, format.String()+format.Extension())
+			file, err := os.Create(path)
+			util.Check(err)
+			util.Check(pattern.Write(file, format, pattern.Pattern{Cells: cells}))
+			util.Check(file.Close())
+
+			tests := []Params{
+				{Input: path},
+				{Input: path, Format: format},
+				{Input: strings.TrimSuffix(path, format.Extension()), Format: format},
+				{Input: path, ImageWidth: 20},
+				{Input: path, ImageHeight: 12},
+			}
+			for _, p := range tests {
+				sized, err := p.WithSize()
+				if err != nil {
+					t.Fatalf("%+v: %v", p, err)
+				}
+				if sized.ImageWidth != 20 || sized.ImageHeight != 12 {
+					t.Errorf("%+v: got %dx%d, expected 20x12", p, sized.ImageWidth, sized.ImageHeight)
+				}
+			}
+		})
+	}
+
+	// a size given in full is kept, without the input file being looked at
+	if p, err := (Params{ImageWidth: 64, ImageHeight: 32, Input: filepath.Join(dir, "missing")}).WithSize(); err != nil || p.ImageWidth != 64 || p.ImageHeight != 32 {
+		t.Errorf("got %dx%d, %v, expected 64x32", p.ImageWidth, p.ImageHeight, err)
+	}
+
+	bad := filepath.Join(dir, "bad.pgm")
+	util.Check(ioutil.WriteFile(bad, []byte("P5\n20"), 0644))
+	unknown := filepath.Join(dir, "world.txt")
+	util.Check(ioutil.WriteFile(unknown, []byte(".O\n"), 0644))
+	for _, p := range []Params{
+		{},
+		{ImageWidth: 16},
+		{Input: filepath.Join(dir, "missing")},
+		{Input: bad},
+		{Input: unknown},
+	} {
+		if _, err := p.WithSize(); err == nil {
+			t.Errorf("%+v: expected an error", p)
+		}
+	}
+}
@@ -1,30 +1,55 @@
 package stubs
 
-import "uk.ac.bris.cs/gameoflife/util"
+import (
+	"time"
+	"uk.ac.bris.cs/gameoflife/util"
+)
 
 type Params struct {
 	Turns       int
 	Threads     int
 	ImageWidth  int
 	ImageHeight int
+	Rule        util.Rule //the zero rule is Conway's
+	Topology    util.Topology //the zero topology is a torus
+	Engine      util.Engine //the zero engine splits the world between workers
+	MaxPeriod   int //longest cycle the broker looks for to end the job early once the world repeats, 0 doesn't look
+}
+
+//the world's first and last few columns, which workers need each turn when the topology twists the sides
+//there are as many as the rule's reach, Left[i] is column i and Right[i] is column i from the right
+type Edges struct {
+	Left [][]uint8
+	Right [][]uint8
 }
 
 type Slice struct {
 	From int //y coordinates
 	To int
+	Halo int //rows of halo either side, as far as the rule's neighbourhood reaches
 }
 
 type EmptyRequest struct {}
 type EmptyResponse struct{}
 
+//every call about a job carries its id, so several controllers can share one broker
+type JobRequest struct {
+	JobID int
+}
+
 
 var SetupHandler = "Gol.Setup"
 type SetupRequest struct {
+	JobID int
 	ID int
 	Slice Slice
-	Params Params
-	World [][]byte
+	Params Params //its Rule is never the zero rule
+	Strip util.Packed //rows From-Halo to To+Halo-1 inclusive, so the first and last Halo rows are halos
 	Turn int
+	Above string //address of the worker holding the rows above this slice
+	Below string //address of the worker holding the rows below this slice
+	Threads int //goroutines the worker should use, 0 leaves it up to the worker
+	Edges Edges //only sent when the topology twists the sides
 }
 type SetupResponse struct {
 	ID int
@@ -33,41 +58,62 @@ type SetupResponse struct {
 
 var TurnHandler = "Gol.TakeTurn"
 type Request struct {
-	World [][]uint8 //whole world
+	JobID int
+	Turn int //the turn the worker should be on before stepping
+	Flips bool //whether to send back the cells that changed
+	Edges Edges //only sent when the topology twists the sides
 }
 type Response struct {
 	ID int
-	Strip [][]uint8 //final strip
+	Strip util.Packed //final strip, only filled in when polling the world
 	Slice Slice
 	Turn int //to report to distributor events
-	Alive []util.Cell //alive cells to report to distributor events
+	AliveCount int //alive cells in the worker's rows after the turn
+	Flipped []util.Cell //cells that changed this turn, only filled in when asked for
+	Levels []uint8 //the grey level each flipped cell changed to
+	Edges Edges //the worker's own rows of the first and last columns, only when the topology twists the sides
+	Active int //cells the worker worked out this turn, the rest of its strip was too far from any change to have changed
+	Bounds util.Rect //around every cell in the worker's rows that isn't dead, only when the topology grows the world
+	Hash uint64 //of the worker's rows, only when the broker is looking for the world repeating
+}
+
+var HaloHandler = "Gol.ReceiveHalo"
+type HaloRequest struct {
+	JobID int
+	Rows util.Packed //top to bottom, as many as the slice's halo
+	Turn int //the turn the rows belong to
+	Top bool //whether the rows are the receiver's top halo or its bottom halo
 }
+//EmptyResponse
 
 var BrokerAliveHandler = "Broker.ReportAlive"
 var AliveHandler = "Gol.ReportAlive"
-//EmptyRequest
+//JobRequest
 type AliveResponse struct {
 	Alive []util.Cell
+	CellsCount int
 	OnTurn int
 }
 
 var SaveWorldHandler = "Broker.SaveWorld"
+//JobRequest
 type WorldResponse struct {
-	World [][]byte
+	World util.Packed
 	OnTurn int
 }
 var PollWorldHandler = "Gol.PollWorld"
-//EmptyRequest
+//JobRequest
 //Response
 
 var BrokerFinishHander = "Broker.Finish"
+//JobRequest
 
 type QuitWorldResponse struct {
 	OnTurn int
 	Alive [] util.Cell
 }
 var FinishHander = "Gol.Finish"
-//EmptyRequest
+//JobRequest
 //EmptyResponse
 
 var KillHandler = "Gol.Kill"
@@ -75,30 +121,154 @@ var KillHandler = "Gol.Kill"
 var KillBroker = "Broker.KillBroker"
 type KillBrokerResponse struct {
 	OnTurn int
-	World [][]byte
+	World util.Packed
 	Alive []util.Cell
 }
-//EmptyRequest
-//EmptyResponse
+//JobRequest
 
 var BrokerPauseHandler = "Broker.PauseGol"
 var PauseHandler = "Gol.PauseGol"
 type PauseRequest struct {
+	JobID int
 	Pause bool
 }
 type PauseResponse struct {
     Turns int
 }
 
+var RegisterHandler = "Broker.RegisterWorker"
+var DeregisterHandler = "Broker.DeregisterWorker"
+type WorkerRequest struct {
+	Ip string //address the broker should dial the worker on
+}
+//EmptyResponse
+
+var PingHandler = "Gol.Ping"
+//EmptyRequest
+type PingResponse struct {
+	Turn int
+	JobID int //job the worker is currently set up for, 0 if none
+}
+
+// Health is how the broker rates a worker from its heartbeats.
+type Health int
+
+const (
+	Healthy Health = iota
+	Suspect
+	Dead
+)
+
+func (h Health) String() string {
+	switch h {
+	case Healthy:
+		return "healthy"
+	case Suspect:
+		return "suspect"
+	case Dead:
+		return "dead"
+	default:
+		return "unknown"
+	}
+}
+
+var ClusterStatusHandler = "Broker.ClusterStatus"
+//EmptyRequest
+type WorkerStatus struct {
+	Ip string
+	State Health
+	LastSeen time.Time
+	Latency time.Duration //round trip of the last answered heartbeat
+	Missed int //heartbeats missed in a row
+	Turn int //turn the worker was on at its last heartbeat
+	JobID int //job the worker was set up for at its last heartbeat
+}
+type JobStatus struct {
+	JobID int
+	Params Params
+	Workers int //workers the job has taken from the pool, 0 while it is idle
+	Idle bool
+	Paused bool
+	QueuePosition int //0 unless the job is waiting for workers
+	Active int //cells the workers worked out on the job's last turn, out of the whole world, the rest were left as they were
+}
+type ClusterStatusResponse struct {
+	Workers []WorkerStatus
+	Jobs []JobStatus
+}
+
+var CreateJobHandler = "Broker.CreateJob"
+type NewJobRequest struct {
+	Params Params
+	Continue bool //picks up a stopped job, or a checkpoint of one if the broker has restarted since
+	JobID int //the job to continue, 0 means the most recent one of the same size
+	Priority int //higher goes first when the broker schedules by priority
+	Watch bool //whether the controller wants frames to draw
+}
+type JobResponse struct {
+	JobID int
+	Continuing bool //whether the broker found a job to continue, rather than starting a new one
+}
+
+var QueueHandler = "Broker.QueuePosition"
+//JobRequest
+type QueueResponse struct {
+	Position int //1 is next to be given workers, 0 means the job isn't queued
+	Length int
+	OnTurn int //the turn the job will start from
+}
+
 var ClientHandler = "Broker.AcceptClient"
 type NewClientRequest struct {
-	World [][]byte
+	JobID int //from CreateJob
+	World util.Packed //ignored when continuing, the broker already has the job's world
 	Params Params
-	Continue bool
 }
 type NewClientResponse struct {
-	World [][]byte
-	Turns int
+	World util.Packed
+	Turns int //-1 if the broker couldn't run the job
 	Alive []util.Cell
+	Issue string //why the broker couldn't run the job
+	Stopped bool //the job was finished or killed before running all its turns, whoever stopped it has the final state
+	Frames []Frame //whatever frames the controller hadn't watched yet when the job finished
+	Period int //how often the world repeats if the broker saw it settle down and ended the job early, 0 if it didn't
+	FirstTurn int //a turn the world is known to repeat from, checked cell for cell, only when Period is set
+}
+
+//a turn's worth of changes for the controller to draw
+type Frame struct {
+	Turn int //turns completed once the frame is drawn
+	Flipped []util.Cell
+	Levels []uint8 //the grey level each cell in Flipped changed to, 255 for alive and 0 for dead unless the rule has dying states
+	Full bool //Flipped holds every cell that isn't dead rather than just the changes, sent when a job starts or rolls back
+	Skipped int //turns merged into this frame because the controller fell behind
+	Origin util.Cell //where the world the cells are in starts on the plane the controller's view is of, which moves as the world grows
+	Jumped bool //the engine went straight to Turn without working out the turns in between, so they don't complete one by one
 }
 
+var WatchHandler = "Broker.WatchFrames"
+//JobRequest
+type FramesResponse struct {
+	Frames []Frame
+}
+
+
+var ListCheckpointsHandler = "Broker.ListCheckpoints"
+//EmptyRequest
+type CheckpointInfo struct {
+	JobID int
+	Turn int
+	Params Params //the size in here is the size the world had grown to, if the topology grows it
+	Saved time.Time
+	Origin util.Cell //where the world's top left cell is on the plane it started on
+	Started util.Cell //the size the job started at, which a controller continuing it without a job id has to ask for
+}
+type CheckpointsResponse struct {
+	Checkpoints []CheckpointInfo //newest first
+}
+
+//what the broker writes to disk, enough to restart a job after the broker itself has gone down
+type Checkpoint struct {
+	CheckpointInfo
+	World [][]byte
+}
@@ -2,12 +2,11 @@ package main
 
 import (
 	"fmt"
-	"io/ioutil"
-	"strconv"
-	"strings"
+	"os"
 	"testing"
 
 	"uk.ac.bris.cs/gameoflife/gol"
+	"uk.ac.bris.cs/gameoflife/pattern"
 	"uk.ac.bris.cs/gameoflife/util"
 )
 
@@ -86,44 +85,20 @@ func assertEqualBoard(t *testing.T, given, expected []util.Cell, p gol.Params) b
 }
 
 func readAliveCells(path string, width, height int) []util.Cell {
-	data, ioError := ioutil.ReadFile(path)
+	file, ioError := os.Open(path)
 	util.Check(ioError)
+	defer file.Close()
 
-	fields := strings.Fields(string(data))
-
-	if fields[0] != "P5" {
-		panic("Not a pgm file")
-	}
+	image, ioError := pattern.Read(file, pattern.FormatOf(path), util.Rule{})
+	util.Check(ioError)
 
-	imageWidth, _ := strconv.Atoi(fields[1])
-	if imageWidth != width {
+	if image.Width() != width {
 		panic("Incorrect width")
 	}
 
-	imageHeight, _ := strconv.Atoi(fields[2])
-	if imageHeight != height {
+	if image.Height() != height {
 		panic("Incorrect height")
 	}
 
-	maxval, _ := strconv.Atoi(fields[3])
-	if maxval != 255 {
-		panic("Incorrect maxval/bit depth")
-	}
-
-	image := []byte(fields[4])
-
-	var cells []util.Cell
-	for y := 0; y < height; y++ {
-		for x := 0; x < width; x++ {
-			cell := image[0]
-			if cell != 0 {
-				cells = append(cells, util.Cell{
-					X: x,
-					Y: y,
-				})
-			}
-			image = image[1:]
-		}
-	}
-	return cells
+	return image.AliveCells()
 }
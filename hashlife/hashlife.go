@@ -0,0 +1,253 @@
+// Package hashlife steps worlds with Gosper's HashLife. The world is kept as a quadtree where every
+// distinct square is only stored once, and what each square becomes is remembered, so patterns that
+// repeat in space or in time are only ever worked out once. That lets it jump a power of two turns at
+// a time, which is how runs of billions of turns finish.
+package hashlife
+
+import (
+	"fmt"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// node is a square 2^level cells a side, split into quarters unless it is a single cell.
+// Nodes never change once made and no square is made twice, so equal squares are the same node.
+type node struct {
+	level          int
+	nw, ne, sw, se *node
+	live           bool // any cell in the square is alive
+}
+
+type quarters struct{ nw, ne, sw, se *node }
+
+type result struct {
+	n *node
+	j int
+}
+
+// Universe steps worlds for one rule and topology. It holds on to every square and result it has
+// worked out between steps, see Size and Reset to keep that in check.
+type Universe struct {
+	rule        util.Rule
+	topology    util.Topology
+	dead, alive *node
+	nodes       map[quarters]*node
+	results     map[result]*node
+}
+
+// New makes a universe for a two state rule over the eight cells around, on a torus or a Klein bottle.
+// HashLife has no edges, so the world is tiled over the whole plane, and those are the only topologies
+// whose worlds tile it.
+func New(rule util.Rule, topology util.Topology) (*Universe, error) {
+	rule = rule.OrConway()
+	if rule.Generations() || !rule.Classic() {
+		return nil, fmt.Errorf("hashlife can only run two state rules over the eight cells around, not %v", rule)
+	}
+	if topology != util.Torus && topology != util.KleinBottle {
+		return nil, fmt.Errorf("hashlife can only run on a torus or a klein bottle, not a %v", topology)
+	}
+	u := &Universe{rule: rule, topology: topology}
+	u.Reset()
+	return u, nil
+}
+
+// Reset forgets every square and result, freeing the memory they take up.
+func (u *Universe) Reset() {
+	u.dead = &node{}
+	u.alive = &node{live: true}
+	u.nodes = make(map[quarters]*node)
+	u.results = make(map[result]*node)
+}
+
+// Size is how many squares and results the universe is holding on to.
+func (u *Universe) Size() int {
+	return len(u.nodes) + len(u.results)
+}
+
+// Step moves a world on by turns, a power of two turns at a time. The world comes back as new rows,
+// 255 for alive and 0 for dead.
+func (u *Universe) Step(world [][]uint8, turns int) [][]uint8 {
+	for j := 0; turns > 0; j++ {
+		if turns&1 == 1 {
+			world = u.jump(world, j)
+		}
+		turns >>= 1
+	}
+	return world
+}
+
+// jump moves a world on by 2^j turns.
+func (u *Universe) jump(world [][]uint8, j int) [][]uint8 {
+	height, width := len(world), len(world[0])
+
+	// what comes back is the middle of the square, half its width, so the square has to be at least
+	// twice the size of the world as well as big enough to jump that far
+	level := j + 2
+	for 1<<uint(level-1) < width || 1<<uint(level-1) < height {
+		level++
+	}
+
+	t := tiling{u: u, world: world, width: width, height: height, px: width, py: height, squares: make(map[square]*node)}
+	if u.topology == util.KleinBottle {
+		t.py = 2 * height // the rows come back round mirrored, and only the second time round the right way
+	}
+	// starting a quarter of the square up and left of the world puts the world at the top left of the middle
+	quarter := 1 << uint(level-2)
+	next := u.successor(t.square(level, -quarter, -quarter), j)
+
+	rows := make([][]uint8, height)
+	for y := range rows {
+		rows[y] = make([]uint8, width)
+	}
+	next.fill(rows, 0, 0)
+	return rows
+}
+
+func (u *Universe) join(nw, ne, sw, se *node) *node {
+	q := quarters{nw, ne, sw, se}
+	if n, ok := u.nodes[q]; ok {
+		return n
+	}
+	n := &node{level: nw.level + 1, nw: nw, ne: ne, sw: sw, se: se, live: nw.live || ne.live || sw.live || se.live}
+	u.nodes[q] = n
+	return n
+}
+
+// successor is the middle of a node, half its width, 2^j turns on. j is at most level-2,
+// as that is as far in as anything from outside the node could have reached.
+func (u *Universe) successor(n *node, j int) *node {
+	key := result{n, j}
+	if next, ok := u.results[key]; ok {
+		return next
+	}
+
+	var next *node
+	if n.level == 2 {
+		next = u.step(n)
+	} else {
+		// the nine overlapping squares half the node's width, moved on all of 2^j turns if that's
+		// less than half the way, otherwise half the way with the other half done below
+		sub := [9]*node{
+			n.nw, u.join(n.nw.ne, n.ne.nw, n.nw.se, n.ne.sw), n.ne,
+			u.join(n.nw.sw, n.nw.se, n.sw.nw, n.sw.ne), u.join(n.nw.se, n.ne.sw, n.sw.ne, n.se.nw), u.join(n.ne.sw, n.ne.se, n.se.nw, n.se.ne),
+			n.sw, u.join(n.sw.ne, n.se.nw, n.sw.se, n.se.sw), n.se,
+		}
+		full := j == n.level-2
+		inner := j
+		if full {
+			inner = j - 1
+		}
+		for i := range sub {
+			sub[i] = u.successor(sub[i], inner)
+		}
+
+		quarter := func(a, b, c, d int) *node {
+			if !full {
+				return u.join(sub[a].se, sub[b].sw, sub[c].ne, sub[d].nw)
+			}
+			return u.successor(u.join(sub[a], sub[b], sub[c], sub[d]), j-1)
+		}
+		next = u.join(quarter(0, 1, 3, 4), quarter(1, 2, 4, 5), quarter(3, 4, 6, 7), quarter(4, 5, 7, 8))
+	}
+
+	u.results[key] = next
+	return next
+}
+
+// step works out the middle 2x2 of a 4x4 node one turn on.
+func (u *Universe) step(n *node) *node {
+	var next [4]*node
+	for i := range next {
+		x, y := 1+i%2, 1+i/2
+		neighbours := 0
+		for dy := -1; dy <= 1; dy++ {
+			for dx := -1; dx <= 1; dx++ {
+				if (dx != 0 || dy != 0) && n.cell(x+dx, y+dy) {
+					neighbours++
+				}
+			}
+		}
+		next[i] = u.dead
+		if u.rule.Next(n.cell(x, y), neighbours) {
+			next[i] = u.alive
+		}
+	}
+	return u.join(next[0], next[1], next[2], next[3])
+}
+
+// cell is whether the cell at x, y of the node is alive.
+func (n *node) cell(x, y int) bool {
+	for n.level > 0 {
+		half := 1 << uint(n.level-1)
+		switch {
+		case x < half && y < half:
+			n = n.nw
+		case y < half:
+			n, x = n.ne, x-half
+		case x < half:
+			n, y = n.sw, y-half
+		default:
+			n, x, y = n.se, x-half, y-half
+		}
+	}
+	return n.live
+}
+
+// fill sets the alive cells of the node to 255 in rows, with the node's top left corner at x, y.
+// Anything that falls outside rows is left out.
+func (n *node) fill(rows [][]uint8, x, y int) {
+	if !n.live || y >= len(rows) || x >= len(rows[0]) {
+		return
+	}
+	if n.level == 0 {
+		rows[y][x] = 255
+		return
+	}
+	half := 1 << uint(n.level-1)
+	n.nw.fill(rows, x, y)
+	n.ne.fill(rows, x+half, y)
+	n.sw.fill(rows, x, y+half)
+	n.se.fill(rows, x+half, y+half)
+}
+
+type square struct{ level, x, y int }
+
+// tiling builds squares of the plane with a world repeated across all of it. The squares only
+// depend on where they are to within a period, px by py, so each is only built once.
+type tiling struct {
+	u             *Universe
+	world         [][]uint8
+	width, height int
+	px, py        int
+	squares       map[square]*node
+}
+
+// square is the square 2^level cells a side with its top left corner at x, y.
+func (t *tiling) square(level, x, y int) *node {
+	x, y = mod(x, t.px), mod(y, t.py)
+	key := square{level, x, y}
+	if n, ok := t.squares[key]; ok {
+		return n
+	}
+
+	var n *node
+	if level == 0 {
+		n = t.u.dead
+		if y >= t.height {
+			// over the end of a Klein bottle, where the world is mirrored
+			x, y = t.width-1-x, y-t.height
+		}
+		if t.world[y][x] != 0 {
+			n = t.u.alive
+		}
+	} else {
+		half := 1 << uint(level-1)
+		n = t.u.join(t.square(level-1, x, y), t.square(level-1, x+half, y), t.square(level-1, x, y+half), t.square(level-1, x+half, y+half))
+	}
+	t.squares[key] = n
+	return n
+}
+
+func mod(a, b int) int {
+	return (a%b + b) % b
+}
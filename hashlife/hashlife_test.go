@@ -0,0 +1,87 @@
+package hashlife
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// naive steps a world a turn at a time, counting every cell's neighbours where the topology puts them.
+func naive(world [][]uint8, turns int, rule util.Rule, topology util.Topology) [][]uint8 {
+	height, width := len(world), len(world[0])
+	for turn := 0; turn < turns; turn++ {
+		next := make([][]uint8, height)
+		for y := range next {
+			next[y] = make([]uint8, width)
+			for x := range next[y] {
+				neighbours := 0
+				for _, offset := range rule.Offsets() {
+					if wx, wy, ok := topology.Wrap(x+offset.X, y+offset.Y, width, height); ok && rule.Alive(world[wy][wx]) {
+						neighbours++
+					}
+				}
+				next[y][x] = rule.Step(world[y][x], neighbours)
+			}
+		}
+		world = next
+	}
+	return world
+}
+
+func randomWorld(seed int64, width, height int) [][]uint8 {
+	random := rand.New(rand.NewSource(seed))
+	world := make([][]uint8, height)
+	for y := range world {
+		world[y] = make([]uint8, width)
+		for x := range world[y] {
+			if random.Intn(3) == 0 {
+				world[y][x] = 255
+			}
+		}
+	}
+	return world
+}
+
+func TestStepMatchesNaive(t *testing.T) {
+	sizes := []util.Cell{{X: 16, Y: 16}, {X: 24, Y: 20}, {X: 7, Y: 33}}
+	for _, rule := range []util.Rule{util.Conway, util.MustParseRule("B36/S23"), util.MustParseRule("B2/S")} {
+		for _, topology := range []util.Topology{util.Torus, util.KleinBottle} {
+			for seed := int64(1); seed <= 3; seed++ {
+				for _, size := range sizes {
+					for _, turns := range []int{1, 3, 6, 13, 50} {
+						name := fmt.Sprintf("%v/%v/seed%d/%dx%d/%d", rule, topology, seed, size.X, size.Y, turns)
+						world := randomWorld(seed, size.X, size.Y)
+						u, err := New(rule, topology)
+						if err != nil {
+							t.Fatal(err)
+						}
+						if got, expected := u.Step(world, turns), naive(world, turns, rule, topology); !reflect.DeepEqual(got, expected) {
+							t.Errorf("%v: HashLife and stepping a turn at a time disagree", name)
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestNewRejects(t *testing.T) {
+	tests := []struct {
+		rule     util.Rule
+		topology util.Topology
+	}{
+		{util.Conway, util.Plane},
+		{util.Conway, util.HorizontalCylinder},
+		{util.MustParseRule("/2/3"), util.Torus},
+		{util.MustParseRule("B2/S34H"), util.Torus},
+		{util.MustParseRule("R2,C0,M1,S5..9,B7..9,NM"), util.Torus},
+	}
+	for _, test := range tests {
+		if _, err := New(test.rule, test.topology); err == nil {
+			t.Errorf("%v on a %v: expected an error", test.rule, test.topology)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"flag"
 	"fmt"
+	"os"
 	"runtime"
 
 	"uk.ac.bris.cs/gameoflife/gol"
@@ -22,14 +23,33 @@ func main() {
 	flag.IntVar(
 		&params.ImageWidth,
 		"w",
-		512,
-		"Specify the width of the image. Defaults to 512.")
+		0,
+		"Specify the width of the image. A pattern narrower than this goes in the middle. Defaults to the width of the -input file, or 512 without one.")
 
 	flag.IntVar(
 		&params.ImageHeight,
 		"h",
-		512,
-		"Specify the height of the image. Defaults to 512.")
+		0,
+		"Specify the height of the image. A pattern shorter than this goes in the middle. Defaults to the height of the -input file, or 512 without one.")
+
+	flag.StringVar(
+		&params.Input,
+		"input",
+		"",
+		"Pattern file to start from, which can leave off its extension. Defaults to images/WxH in whichever format is there.")
+
+	flag.StringVar(
+		&params.OutputDir,
+		"out",
+		"out",
+		"Directory worlds, pictures and recordings are written to, made if it isn't there. Defaults to out.")
+
+	flag.StringVar(
+		&params.Filename,
+		"name",
+		gol.DefaultFilename,
+		"What files written are called, without the extension. {width}, {height}, {turn}, {rule}, {timestamp} and {input}, the input file's name, are filled in. "+
+			"Defaults to "+gol.DefaultFilename+".")
 
 	flag.IntVar(
 		&params.Turns, //number of times you run the algorithm on the input image
@@ -37,6 +57,61 @@ func main() {
 		10000000000,
 		"Specify the number of turns to process. Defaults to 10000000000.")
 
+	flag.Var(
+		&params.Rule,
+		"rule",
+		"Life-like rule to run as a B/S rulestring, e.g. B36/S23 for HighLife, or with a number of states for a Generations rule, e.g. /2/3 for Brian's Brain. "+
+			"End it in V or H for the von Neumann or hexagonal neighbourhood, or give a Larger than Life rule such as R5,C0,M1,S34..58,B34..45,NM. Defaults to B3/S23.")
+
+	flag.Var(
+		&params.Topology,
+		"topology",
+		"How the edges of the world join up: torus, plane, hcylinder, vcylinder, klein or cross, or infinite for an endless plane the world grows across. Defaults to torus.")
+
+	flag.Var(
+		&params.Engine,
+		"engine",
+		"What works out the turns: strips, split between the broker's workers, or hashlife, run on the broker and able to jump billions of turns. "+
+			"HashLife only runs rules over the eight cells around without dying states, on a torus or klein bottle. Defaults to strips.")
+
+	flag.Var(
+		&params.Format,
+		"format",
+		"What the world is read and written as: pgm or pbm, written raw or as plainpgm or plainpbm text and read either way, "+
+			"rle for run length encoded patterns, cells for plaintext ones or life106 for a list of alive cells' coordinates. "+
+			"Patterns smaller than the world go in the middle unless they give coordinates, and RLE patterns can give the rule to run. "+
+			"Defaults to auto, reading whichever of images/WxH.pgm, .pbm, .rle, .cells and .lif is there and writing the same format.")
+
+	flag.IntVar(
+		&params.MaxPeriod,
+		"period",
+		0,
+		"Longest cycle the broker looks for, so a world that has settled into still lifes and oscillators stops early with the world it would have ended on. "+
+			"Defaults to 0, not looking.")
+
+	flag.BoolVar(
+		&params.PNG,
+		"png",
+		false,
+		"Also write a PNG picture of the world next to every world file written to out.")
+
+	flag.IntVar(
+		&params.Record,
+		"gif",
+		0,
+		"Record every nth turn to an animated GIF, written to out when the run ends. Works with -noVis too. Defaults to 0, not recording.")
+
+	flag.IntVar(
+		&params.Picture.Scale,
+		"scale",
+		1,
+		"How many pixels a side each cell is drawn as in PNGs and GIFs. Defaults to 1.")
+
+	flag.Var(
+		&params.Picture.Palette,
+		"palette",
+		"Colours PNGs and GIFs are drawn in: mono, paper, matrix or ember, or the dead and alive colours as #rrggbb,#rrggbb. Defaults to mono.")
+
 	noVis := flag.Bool(
 		"noVis",
 		false,
@@ -47,12 +122,78 @@ func main() {
 		false,
 		"Continue from the previous job, or refresh the broker's state")
 
-    //server := flag.String("server", "127.0.0.1:8030", "IP:port")
+	flag.IntVar(
+		&params.Job,
+		"job",
+		0,
+		"With -continue, the id of the job to pick back up. Defaults to the most recent one of the same size.")
+
+	flag.IntVar(
+		&params.Priority,
+		"priority",
+		0,
+		"Where the job goes in the broker's queue when it schedules by priority, higher goes first. Defaults to 0.")
+
+	flag.StringVar(
+		&params.Broker.Address,
+		"server",
+		"",
+		"Broker address as IP:port. Defaults to $GOL_BROKER, or localhost:8031.")
+
+	flag.DurationVar(
+		&params.Broker.DialTimeout,
+		"dial_timeout",
+		0,
+		"How long to wait for the broker to answer each dial. Defaults to $GOL_DIAL_TIMEOUT, or however long the OS waits.")
+
+	flag.IntVar(
+		&params.Broker.Retries,
+		"retries",
+		0,
+		"How many more times to dial the broker if it doesn't answer. Defaults to $GOL_RETRIES, or 0.")
+
+	flag.DurationVar(
+		&params.Broker.Backoff,
+		"backoff",
+		0,
+		"How long to wait before dialling the broker again, doubled after each retry. Defaults to $GOL_BACKOFF, or 500ms.")
+
+	flag.DurationVar(
+		&params.Broker.CallTimeout,
+		"call_timeout",
+		0,
+		"Deadline on each call to the broker, other than the one running the job. Defaults to $GOL_CALL_TIMEOUT, or none.")
+
 	flag.Parse()
+	params.NoView = *noVis
+	//without an input file the world is read from images, which are named after their size
+	if params.Input == "" {
+		if params.ImageWidth == 0 {
+			params.ImageWidth = 512
+		}
+		if params.ImageHeight == 0 {
+			params.ImageHeight = 512
+		}
+	}
+	params, err := params.WithSize()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 
 	fmt.Println("Threads:", params.Threads)
 	fmt.Println("Width:", params.ImageWidth)
 	fmt.Println("Height:", params.ImageHeight)
+	fmt.Println("Input:", params.Input)
+	fmt.Println("Output:", params.OutputDir+"/"+params.Filename)
+	fmt.Println("Rule:", params.Rule)
+	fmt.Println("Topology:", params.Topology)
+	fmt.Println("Engine:", params.Engine)
+	fmt.Println("Format:", params.Format)
+	fmt.Println("Period:", params.MaxPeriod)
+	fmt.Println("PNG:", params.PNG)
+	fmt.Println("GIF every:", params.Record)
+	fmt.Println("Palette:", params.Picture.Palette)
 	fmt.Println("Continuing? ", *cont)
 
 	keyPresses := make(chan rune, 10) //captured by sdl window
@@ -65,9 +206,14 @@ func main() {
 		complete := false
 		for !complete {
 			event := <-events
-			switch event.(type) {
+			switch e := event.(type) {
 			case gol.FinalTurnComplete:
 				complete = true
+			case gol.JobStarted, gol.StabilisedDetected:
+				fmt.Println(e)
+			case gol.RunError:
+				fmt.Println(e)
+				os.Exit(1)
 			}
 		}
 	}
@@ -0,0 +1,73 @@
+package pattern
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// readCells reads a plaintext pattern: comment lines starting with !, then a line a row with . for
+// dead cells and O for alive ones. Rows can stop short, the rest of the row is dead.
+func readCells(r io.Reader) (Pattern, error) {
+	lines := bufio.NewScanner(r)
+	lines.Buffer(nil, 1<<20)
+
+	var rows []string
+	width := 0
+	for lines.Scan() {
+		line := strings.TrimRight(lines.Text(), " \t\r")
+		if strings.HasPrefix(line, "!") {
+			continue
+		}
+		rows = append(rows, line)
+		if len(line) > width {
+			width = len(line)
+		}
+		if width*len(rows) > maxCells {
+			return Pattern{}, fmt.Errorf("the pattern is too big")
+		}
+	}
+	if err := lines.Err(); err != nil {
+		return Pattern{}, err
+	}
+	// trailing blank lines are the end of the file rather than rows of dead cells
+	for len(rows) > 0 && rows[len(rows)-1] == "" {
+		rows = rows[:len(rows)-1]
+	}
+
+	p := Pattern{Cells: make([][]uint8, len(rows))}
+	for y, row := range rows {
+		p.Cells[y] = make([]uint8, width)
+		for x, c := range []byte(row) {
+			switch c {
+			case '.':
+			case 'O', 'o', '*':
+				p.Cells[y][x] = 255
+			default:
+				return Pattern{}, fmt.Errorf("unexpected %q on row %d", c, y)
+			}
+		}
+	}
+	return p, nil
+}
+
+// writeCells writes a plaintext pattern. Every row is written out in full so the pattern keeps its size,
+// and dying cells are written as dead.
+func writeCells(w io.Writer, p Pattern) error {
+	out := bufio.NewWriter(w)
+	rule := p.Rule.OrConway()
+	fmt.Fprintf(out, "!Name: %dx%d\n!Rule: %v\n", p.Width(), p.Height(), rule)
+	line := make([]byte, p.Width()+1)
+	line[len(line)-1] = '\n'
+	for _, row := range p.Cells {
+		for x, level := range row {
+			line[x] = '.'
+			if rule.Alive(level) {
+				line[x] = 'O'
+			}
+		}
+		out.Write(line)
+	}
+	return out.Flush()
+}
@@ -0,0 +1,88 @@
+package pattern
+
+import (
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+func TestCellsRoundTrip(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	for _, size := range []util.Cell{{X: 1, Y: 1}, {X: 3, Y: 5}, {X: 100, Y: 7}} {
+		p := Pattern{Cells: randomCells(random, size.X, size.Y, util.Conway)}
+		// rows are written out in full, so dead edges keep the size
+		p.Cells[size.Y-1] = make([]uint8, size.X)
+		p.Cells[0][size.X-1] = 0
+
+		if read := roundTrip(t, Cells, p); !reflect.DeepEqual(read.Cells, p.Cells) {
+			t.Errorf("%dx%d: read back\n%v\nexpected\n%v", size.X, size.Y, read.Cells, p.Cells)
+		}
+	}
+}
+
+func TestCellsWritesDyingCellsAsDead(t *testing.T) {
+	rule := util.MustParseRule("/2/3")
+	p := Pattern{Cells: [][]uint8{{rule.Level(1), rule.Level(2), 0}}, Rule: rule}
+	if read := roundTrip(t, Cells, p); !reflect.DeepEqual(read.Cells, [][]uint8{{255, 0, 0}}) {
+		t.Errorf("read back %v, expected [[255 0 0]]", read.Cells)
+	}
+}
+
+func TestReadCells(t *testing.T) {
+	file := "!Name: short rows\n!\n.O\n\nO.*o  \n\n\n"
+	expected := [][]uint8{{0, 255, 0, 0}, {0, 0, 0, 0}, {255, 0, 255, 255}}
+	p, err := Read(strings.NewReader(file), Cells, util.Rule{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(p.Cells, expected) {
+		t.Errorf("read\n%v\nexpected\n%v", p.Cells, expected)
+	}
+}
+
+func TestReadCellsErrors(t *testing.T) {
+	expectErrors(t, Cells, []string{
+		".O.\nOXO\n",
+		"!Name: tabs\n.\tO\n",
+		"bo$o!\n",
+	})
+}
+
+func TestLife106RoundTrip(t *testing.T) {
+	cells := []util.Cell{{X: -3, Y: -2}, {X: 0, Y: 0}, {X: 5, Y: -2}, {X: 1, Y: 4}}
+	p := FromCells(cells)
+	read := roundTrip(t, Life106, p)
+	if !read.Absolute || read.Origin != p.Origin || !reflect.DeepEqual(read.Cells, p.Cells) {
+		t.Errorf("read back %v at %v, expected %v at %v", read.Cells, read.Origin, p.Cells, p.Origin)
+	}
+	if alive := read.AliveCells(); len(alive) != len(cells) {
+		t.Errorf("read back the alive cells %v, expected %v", alive, cells)
+	}
+}
+
+func TestReadLife106(t *testing.T) {
+	file := "#Life 1.06\n#D a comment\n  2 -1\n\n0 1\n"
+	p, err := Read(strings.NewReader(file), Life106, util.Rule{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []util.Cell{{X: 2, Y: -1}, {X: 0, Y: 1}}
+	if alive := p.AliveCells(); !reflect.DeepEqual(alive, expected) || p.Width() != 3 || p.Height() != 3 {
+		t.Errorf("read %v in a %dx%d pattern, expected %v in a 3x3 one", alive, p.Width(), p.Height(), expected)
+	}
+}
+
+func TestReadLife106Errors(t *testing.T) {
+	expectErrors(t, Life106, []string{
+		"",
+		"0 0\n",
+		"#Life 1.05\n0 0\n",
+		"#Life 1.06\n1\n",
+		"#Life 1.06\n1 2 3\n",
+		"#Life 1.06\na b\n",
+		"#Life 1.06\n0 0\n100000 100000\n",
+	})
+}
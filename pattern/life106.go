@@ -0,0 +1,65 @@
+package pattern
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+const life106Header = "#Life 1.06"
+
+// readLife106 reads a Life 1.06 pattern: the #Life 1.06 header, then the x and y of an alive cell on each line.
+// The pattern is just big enough to hold the cells, and keeps their coordinates.
+func readLife106(r io.Reader) (Pattern, error) {
+	lines := bufio.NewScanner(r)
+	if !lines.Scan() || strings.TrimSpace(lines.Text()) != life106Header {
+		if err := lines.Err(); err != nil {
+			return Pattern{}, err
+		}
+		return Pattern{}, fmt.Errorf("no %s header line", life106Header)
+	}
+
+	var cells []util.Cell
+	var bounds util.Rect
+	for n := 2; lines.Scan(); n++ {
+		line := strings.TrimSpace(lines.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return Pattern{}, fmt.Errorf("line %d is %q, not an x and a y", n, line)
+		}
+		x, errX := strconv.Atoi(fields[0])
+		y, errY := strconv.Atoi(fields[1])
+		if errX != nil || errY != nil {
+			return Pattern{}, fmt.Errorf("line %d is %q, not an x and a y", n, line)
+		}
+		cell := util.Cell{X: x, Y: y}
+		cells = append(cells, cell)
+		bounds = bounds.Union(util.Rect{Min: cell, Max: util.Cell{X: x + 1, Y: y + 1}})
+		width, height := bounds.Max.X-bounds.Min.X, bounds.Max.Y-bounds.Min.Y
+		if width > maxCells || height > maxCells || width*height > maxCells {
+			return Pattern{}, fmt.Errorf("the cells are too far apart")
+		}
+	}
+	if err := lines.Err(); err != nil {
+		return Pattern{}, err
+	}
+	return FromCells(cells), nil
+}
+
+// writeLife106 writes the coordinates of the pattern's alive cells a row at a time, so the same alive
+// cells always make the same file. Dying cells are left out.
+func writeLife106(w io.Writer, p Pattern) error {
+	out := bufio.NewWriter(w)
+	fmt.Fprintln(out, life106Header)
+	for _, cell := range p.AliveCells() {
+		fmt.Fprintf(out, "%d %d\n", cell.X, cell.Y)
+	}
+	return out.Flush()
+}
@@ -0,0 +1,233 @@
+// Package pattern reads and writes worlds in the file formats Life patterns are kept and shared in.
+// Worlds are grey levels the same as everywhere else, 255 for alive, 0 for dead and the levels in
+// between for the dying states of a Generations rule.
+package pattern
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// Format is a pattern file format.
+type Format int
+
+const (
+	Auto     Format = iota // whichever format the file's extension says
+	PGM                    // greymap, a byte a cell
+	PBM                    // bitmap, a bit a cell
+	RLE                    // run length encoded, the format most patterns online come in
+	Cells                  // plaintext, a line a row with . for dead and O for alive
+	Life106                // Life 1.06, the x y coordinates of each alive cell a line at a time
+	PlainPGM               // greymap written out as decimal numbers, read the same as PGM
+	PlainPBM               // bitmap written out as 0s and 1s, read the same as PBM
+)
+
+// Formats lists the formats files can be in, in the order a file of unknown format is looked for in.
+var Formats = []Format{PGM, PBM, RLE, Cells, Life106, PlainPGM, PlainPBM}
+
+var formatNames = map[Format]string{
+	Auto:     "auto",
+	PGM:      "pgm",
+	PBM:      "pbm",
+	RLE:      "rle",
+	Cells:    "cells",
+	Life106:  "life106",
+	PlainPGM: "plainpgm",
+	PlainPBM: "plainpbm",
+}
+
+// the first extension of each is the one files are written with
+var formatExtensions = map[Format][]string{
+	PGM:      {".pgm"},
+	PBM:      {".pbm"},
+	RLE:      {".rle"},
+	Cells:    {".cells"},
+	Life106:  {".lif", ".life"},
+	PlainPGM: {".pgm"},
+	PlainPBM: {".pbm"},
+}
+
+// the most cells a pattern read from a file can take up, so a bad header can't ask for all the memory there is
+const maxCells = 1 << 28
+
+// ParseFormat reads a format by its name: auto, pgm, pbm, rle, cells, life106, plainpgm or plainpbm.
+func ParseFormat(s string) (Format, error) {
+	for format, name := range formatNames {
+		if strings.EqualFold(strings.TrimSpace(s), name) {
+			return format, nil
+		}
+	}
+	return Auto, fmt.Errorf("unknown format %q, expected one of auto, pgm, pbm, rle, cells, life106, plainpgm or plainpbm", s)
+}
+
+func (f Format) String() string {
+	if name, ok := formatNames[f]; ok {
+		return name
+	}
+	return fmt.Sprintf("Format(%d)", int(f))
+}
+
+// Set parses a format name into f, so it can be given as a flag.
+func (f *Format) Set(s string) (err error) {
+	*f, err = ParseFormat(s)
+	return
+}
+
+// Extension is the extension files in the format are saved with, including the dot. Auto has none.
+func (f Format) Extension() string {
+	if extensions := formatExtensions[f]; len(extensions) > 0 {
+		return extensions[0]
+	}
+	return ""
+}
+
+// FormatOf picks a file's format from its extension, or Auto if it isn't the extension of any of Formats.
+func FormatOf(path string) Format {
+	for _, format := range Formats {
+		for _, extension := range formatExtensions[format] {
+			if strings.EqualFold(filepath.Ext(path), extension) {
+				return format
+			}
+		}
+	}
+	return Auto
+}
+
+// Pattern is a world as it is kept in a file.
+type Pattern struct {
+	Cells [][]uint8 // rows of grey levels, all the same length
+	Rule  util.Rule // the rule the file says the pattern runs under, the zero Rule if it doesn't say
+
+	// Absolute says the file gives where its cells are, with the top left of Cells at Origin.
+	// Otherwise the pattern has no place of its own and goes in the middle of the world.
+	Absolute bool
+	Origin   util.Cell
+}
+
+// FromCells makes a pattern of the alive cells given, just big enough to hold them and at their coordinates.
+func FromCells(cells []util.Cell) Pattern {
+	var bounds util.Rect
+	for _, cell := range cells {
+		bounds = bounds.Union(util.Rect{Min: cell, Max: util.Cell{X: cell.X + 1, Y: cell.Y + 1}})
+	}
+
+	p := Pattern{Cells: make([][]uint8, bounds.Max.Y-bounds.Min.Y), Absolute: true, Origin: bounds.Min}
+	for y := range p.Cells {
+		p.Cells[y] = make([]uint8, bounds.Max.X-bounds.Min.X)
+	}
+	for _, cell := range cells {
+		p.Cells[cell.Y-bounds.Min.Y][cell.X-bounds.Min.X] = 255
+	}
+	return p
+}
+
+// AliveCells lists the pattern's alive cells a row at a time, at their coordinates if it has them.
+func (p Pattern) AliveCells() []util.Cell {
+	rule := p.Rule.OrConway()
+	var cells []util.Cell
+	for y, row := range p.Cells {
+		for x, level := range row {
+			if rule.Alive(level) {
+				cells = append(cells, util.Cell{X: x + p.Origin.X, Y: y + p.Origin.Y})
+			}
+		}
+	}
+	return cells
+}
+
+// Width is how many cells across the pattern is.
+func (p Pattern) Width() int {
+	if len(p.Cells) == 0 {
+		return 0
+	}
+	return len(p.Cells[0])
+}
+
+// Height is how many rows the pattern has.
+func (p Pattern) Height() int {
+	return len(p.Cells)
+}
+
+// Read reads a pattern in the given format, which can't be Auto. Files that number their cells' states
+// without saying what rule they run under have them read as the states of rule.
+func Read(r io.Reader, format Format, rule util.Rule) (Pattern, error) {
+	switch format {
+	case PGM, PBM, PlainPGM, PlainPBM:
+		return readPNM(r)
+	case RLE:
+		return readRLE(r, rule)
+	case Cells:
+		return readCells(r)
+	case Life106:
+		return readLife106(r)
+	}
+	return Pattern{}, fmt.Errorf("can't read patterns in the %v format", format)
+}
+
+// Size reads how many cells across and down a pattern in the given format is, which can't be Auto.
+// Only the header is read when the format has one, the whole pattern when it doesn't.
+func Size(r io.Reader, format Format) (width, height int, err error) {
+	switch format {
+	case PGM, PBM, PlainPGM, PlainPBM:
+		_, width, height, err = pnmReader{r: bufio.NewReader(r)}.header()
+		return width, height, err
+	case RLE:
+		lines := bufio.NewScanner(r)
+		lines.Buffer(nil, 1<<20)
+		var rule util.Rule
+		return readRLEStart(lines, &rule)
+	}
+	p, err := Read(r, format, util.Rule{})
+	return p.Width(), p.Height(), err
+}
+
+// Write writes a pattern in the given format, which can't be Auto.
+func Write(w io.Writer, format Format, p Pattern) error {
+	switch format {
+	case PGM:
+		return writePNM(w, p, '5')
+	case PBM:
+		return writePNM(w, p, '4')
+	case PlainPGM:
+		return writePNM(w, p, '2')
+	case PlainPBM:
+		return writePNM(w, p, '1')
+	case RLE:
+		return writeRLE(w, p)
+	case Cells:
+		return writeCells(w, p)
+	case Life106:
+		return writeLife106(w, p)
+	}
+	return fmt.Errorf("can't write patterns in the %v format", format)
+}
+
+// Place puts the pattern in a world width by height, with dead cells all around it. It goes where its
+// coordinates say if it has them and they are inside the world, and in the middle otherwise.
+// A pattern the same size as the world comes back as it is, one bigger than it is an error.
+func (p Pattern) Place(width, height int) (Pattern, error) {
+	if p.Width() > width || p.Height() > height {
+		return p, fmt.Errorf("the pattern is %dx%d, too big for a %dx%d world", p.Width(), p.Height(), width, height)
+	}
+	if p.Width() == width && p.Height() == height {
+		return Pattern{Cells: p.Cells, Rule: p.Rule}, nil
+	}
+
+	left, top := (width-p.Width())/2, (height-p.Height())/2
+	if p.Absolute && p.Origin.X >= 0 && p.Origin.Y >= 0 && p.Origin.X+p.Width() <= width && p.Origin.Y+p.Height() <= height {
+		left, top = p.Origin.X, p.Origin.Y
+	}
+	placed := Pattern{Cells: make([][]uint8, height), Rule: p.Rule}
+	for y := range placed.Cells {
+		placed.Cells[y] = make([]uint8, width)
+		if y >= top && y < top+p.Height() {
+			copy(placed.Cells[y][left:], p.Cells[y-top])
+		}
+	}
+	return placed, nil
+}
@@ -0,0 +1,88 @@
+package pattern
+
+import (
+	"bytes"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// randomCells makes rows of cells in random states of the rule.
+func randomCells(random *rand.Rand, width, height int, rule util.Rule) [][]uint8 {
+	cells := make([][]uint8, height)
+	for y := range cells {
+		cells[y] = make([]uint8, width)
+		for x := range cells[y] {
+			if random.Intn(3) == 0 {
+				cells[y][x] = rule.Level(1 + random.Intn(rule.States-1))
+			}
+		}
+	}
+	return cells
+}
+
+// roundTrip writes the pattern in the format and reads it back.
+func roundTrip(t *testing.T, format Format, p Pattern) Pattern {
+	var file bytes.Buffer
+	if err := Write(&file, format, p); err != nil {
+		t.Fatalf("%v: %v", format, err)
+	}
+	read, err := Read(&file, format, p.Rule)
+	if err != nil {
+		t.Fatalf("%v: %v\n%s", format, err, file.String())
+	}
+	return read
+}
+
+// expectErrors reads each file in the format, expecting an error every time and never a panic.
+func expectErrors(t *testing.T, format Format, files []string) {
+	for _, file := range files {
+		p, err := Read(strings.NewReader(file), format, util.Rule{})
+		if err == nil {
+			t.Errorf("%v %q: expected an error, read %v", format, file, p.Cells)
+		}
+	}
+}
+
+func TestPlace(t *testing.T) {
+	glider := Pattern{Cells: [][]uint8{{0, 255, 0}, {0, 0, 255}, {255, 255, 255}}}
+
+	placed, err := glider.Place(5, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := [][]uint8{
+		{0, 0, 0, 0, 0},
+		{0, 0, 255, 0, 0},
+		{0, 0, 0, 255, 0},
+		{0, 255, 255, 255, 0},
+		{0, 0, 0, 0, 0},
+	}
+	if !reflect.DeepEqual(placed.Cells, expected) {
+		t.Errorf("placed in the middle as\n%v\nexpected\n%v", placed.Cells, expected)
+	}
+
+	glider.Absolute, glider.Origin = true, util.Cell{X: 2, Y: 1}
+	if placed, err = glider.Place(5, 5); err != nil {
+		t.Fatal(err)
+	}
+	if placed.Cells[1][3] != 255 || placed.Cells[3][2] != 255 || placed.Cells[3][4] != 255 {
+		t.Errorf("not placed at its coordinates: %v", placed.Cells)
+	}
+
+	// coordinates that would put it partly outside the world are ignored
+	glider.Origin = util.Cell{X: 3, Y: -1}
+	if placed, err = glider.Place(5, 5); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(placed.Cells, expected) {
+		t.Errorf("placed outside the middle as\n%v\nexpected\n%v", placed.Cells, expected)
+	}
+
+	if _, err := glider.Place(2, 5); err == nil {
+		t.Errorf("expected an error placing a 3x3 pattern in a 2x5 world")
+	}
+}
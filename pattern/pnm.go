@@ -0,0 +1,285 @@
+package pattern
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// the longest line written in the plain formats, as the netpbm tools expect
+const plainLineLength = 70
+
+// readPNM reads any of the four netpbm formats for bitmaps and greymaps, going by the magic number at
+// the start rather than the extension: P1 and P4 bitmaps, plain and raw, and P2 and P5 greymaps, plain
+// and raw. Comments from # to the end of the line can go anywhere before the cells, and in among them
+// in the plain formats.
+//
+// Bitmaps have 1 for alive. Greymap levels of any maxval are scaled to 0 to 255, rounding to the
+// nearest, so with a maxval over 255 a level under half of one step up from black counts as dead.
+func readPNM(r io.Reader) (Pattern, error) {
+	in := pnmReader{r: bufio.NewReader(r)}
+	kind, width, height, err := in.header()
+	if err != nil {
+		return Pattern{}, err
+	}
+	bitmap := kind == '1' || kind == '4'
+
+	maxval := 1
+	if !bitmap {
+		if maxval, err = in.number("maxval"); err != nil {
+			return Pattern{}, cutShort(err)
+		}
+		if maxval < 1 || maxval > 65535 {
+			return Pattern{}, fmt.Errorf("maxval is %d, it has to be from 1 to 65535", maxval)
+		}
+	}
+	// the raw formats' cells start after exactly one whitespace byte, which could be a cell of the plain formats
+	if kind == '4' || kind == '5' {
+		if c, err := in.r.ReadByte(); err != nil || !isSpace(c) {
+			return Pattern{}, fmt.Errorf("no whitespace between the header and the cells")
+		}
+	}
+
+	p := Pattern{Cells: make([][]uint8, height)}
+	for y := range p.Cells {
+		p.Cells[y] = make([]uint8, width)
+	}
+	switch kind {
+	case '1':
+		err = in.plainBits(p.Cells)
+	case '2':
+		err = in.plainLevels(p.Cells, maxval)
+	case '4':
+		err = in.rawBits(p.Cells)
+	case '5':
+		err = in.rawLevels(p.Cells, maxval)
+	}
+	return p, cutShort(err)
+}
+
+// cutShort gives a clearer error for running out of file part way through.
+func cutShort(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return fmt.Errorf("the image is cut short")
+	}
+	return err
+}
+
+type pnmReader struct {
+	r *bufio.Reader
+}
+
+// header reads the magic number, which is P and the kind of file, and the size.
+func (in pnmReader) header() (kind byte, width, height int, err error) {
+	magic := make([]byte, 2)
+	if _, err := io.ReadFull(in.r, magic); err != nil || magic[0] != 'P' || magic[1] < '1' || magic[1] > '5' || magic[1] == '3' {
+		return 0, 0, 0, fmt.Errorf("not a pbm or pgm file")
+	}
+	if width, err = in.number("width"); err != nil {
+		return 0, 0, 0, cutShort(err)
+	}
+	if height, err = in.number("height"); err != nil {
+		return 0, 0, 0, cutShort(err)
+	}
+	if width > maxCells || height > maxCells || width*height > maxCells {
+		return 0, 0, 0, fmt.Errorf("%dx%d is too big an image", width, height)
+	}
+	return magic[1], width, height, nil
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\v' || c == '\f'
+}
+
+// skip moves past whitespace and comments to the start of the next token.
+func (in pnmReader) skip() error {
+	for {
+		c, err := in.r.ReadByte()
+		if err != nil {
+			return err
+		}
+		switch {
+		case c == '#':
+			if _, err := in.r.ReadString('\n'); err != nil {
+				return err
+			}
+		case !isSpace(c):
+			return in.r.UnreadByte()
+		}
+	}
+}
+
+// number reads the next decimal number, what says what it is for if it isn't one.
+func (in pnmReader) number(what string) (int, error) {
+	if err := in.skip(); err != nil {
+		return 0, err
+	}
+	n, digits := 0, 0
+	for {
+		c, err := in.r.ReadByte()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		if c < '0' || c > '9' {
+			in.r.UnreadByte()
+			break
+		}
+		if n = n*10 + int(c-'0'); n > maxCells {
+			return 0, fmt.Errorf("the %s is far too big", what)
+		}
+		digits++
+	}
+	if digits == 0 {
+		return 0, fmt.Errorf("the %s isn't a number", what)
+	}
+	return n, nil
+}
+
+// plainBits reads P1 cells, 0 or 1 each, which don't need any whitespace between them.
+func (in pnmReader) plainBits(cells [][]uint8) error {
+	for _, row := range cells {
+		for x := range row {
+			if err := in.skip(); err != nil {
+				return err
+			}
+			c, _ := in.r.ReadByte()
+			switch c {
+			case '0':
+			case '1':
+				row[x] = 255
+			default:
+				return fmt.Errorf("unexpected %q in the cells", c)
+			}
+		}
+	}
+	return nil
+}
+
+// plainLevels reads P2 cells, a decimal level each.
+func (in pnmReader) plainLevels(cells [][]uint8, maxval int) error {
+	for _, row := range cells {
+		for x := range row {
+			level, err := in.number("cell")
+			if err != nil {
+				return err
+			}
+			if level > maxval {
+				return fmt.Errorf("level %d is over the maxval of %d", level, maxval)
+			}
+			row[x] = scale(level, maxval)
+		}
+	}
+	return nil
+}
+
+// rawBits reads P4 cells, eight to a byte with the first in the top bit, each row starting on a new byte.
+func (in pnmReader) rawBits(cells [][]uint8) error {
+	for _, row := range cells {
+		packed := make([]byte, (len(row)+7)/8)
+		if _, err := io.ReadFull(in.r, packed); err != nil {
+			return err
+		}
+		for x := range row {
+			if packed[x/8]&(0x80>>uint(x%8)) != 0 {
+				row[x] = 255
+			}
+		}
+	}
+	return nil
+}
+
+// rawLevels reads P5 cells, a byte each, or two with the high byte first when maxval is over 255.
+func (in pnmReader) rawLevels(cells [][]uint8, maxval int) error {
+	size := 1
+	if maxval > 255 {
+		size = 2
+	}
+	for _, row := range cells {
+		raw := make([]byte, len(row)*size)
+		if _, err := io.ReadFull(in.r, raw); err != nil {
+			return err
+		}
+		for x := range row {
+			level := int(raw[x*size])
+			if size == 2 {
+				level = level<<8 | int(raw[x*size+1])
+			}
+			if level > maxval {
+				return fmt.Errorf("level %d is over the maxval of %d", level, maxval)
+			}
+			row[x] = scale(level, maxval)
+		}
+	}
+	return nil
+}
+
+// scale takes a level out of maxval to the nearest out of 255.
+func scale(level, maxval int) uint8 {
+	if maxval == 255 {
+		return uint8(level)
+	}
+	return uint8((level*255 + maxval/2) / maxval)
+}
+
+// writePNM writes a pattern in one of the four netpbm formats readPNM reads, given by its magic number's
+// digit. Bitmaps have 1 for alive and dying cells count as dead. Greymaps have a maxval of 255.
+func writePNM(w io.Writer, p Pattern, kind byte) error {
+	out := bufio.NewWriter(w)
+	rule := p.Rule.OrConway()
+	bitmap := kind == '1' || kind == '4'
+
+	fmt.Fprintf(out, "P%c\n%d %d\n", kind, p.Width(), p.Height())
+	if !bitmap {
+		fmt.Fprintf(out, "255\n")
+	}
+
+	length := 0
+	plain := func(token string) {
+		if length > 0 && length+1+len(token) > plainLineLength {
+			out.WriteByte('\n')
+			length = 0
+		} else if length > 0 {
+			out.WriteByte(' ')
+			length++
+		}
+		out.WriteString(token)
+		length += len(token)
+	}
+
+	for _, row := range p.Cells {
+		switch kind {
+		case '1':
+			for _, level := range row {
+				if rule.Alive(level) {
+					plain("1")
+				} else {
+					plain("0")
+				}
+			}
+		case '2':
+			for _, level := range row {
+				plain(strconv.Itoa(int(level)))
+			}
+		case '4':
+			packed := make([]byte, (len(row)+7)/8)
+			for x, level := range row {
+				if rule.Alive(level) {
+					packed[x/8] |= 0x80 >> uint(x%8)
+				}
+			}
+			out.Write(packed)
+		case '5':
+			out.Write(row)
+		}
+		// the plain formats start every row on a new line, so they can be read by eye
+		if kind == '1' || kind == '2' {
+			out.WriteByte('\n')
+			length = 0
+		}
+	}
+	return out.Flush()
+}
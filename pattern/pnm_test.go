@@ -0,0 +1,124 @@
+package pattern
+
+import (
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+func TestPNMRoundTrip(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	// widths that aren't a multiple of 8 pad each P4 row out to a whole byte
+	for _, size := range []util.Cell{{X: 1, Y: 1}, {X: 10, Y: 3}, {X: 64, Y: 5}, {X: 100, Y: 7}} {
+		grey := make([][]uint8, size.Y)
+		for y := range grey {
+			grey[y] = make([]uint8, size.X)
+			for x := range grey[y] {
+				grey[y][x] = uint8(random.Intn(256))
+			}
+		}
+		// raw greymaps can have cells that are whitespace or #, which mustn't be taken as part of the header
+		grey[0][0] = ' '
+		grey[size.Y-1][size.X-1] = '#'
+		bits := randomCells(random, size.X, size.Y, util.Conway)
+
+		for _, format := range []Format{PGM, PlainPGM} {
+			if read := roundTrip(t, format, Pattern{Cells: grey}); !reflect.DeepEqual(read.Cells, grey) {
+				t.Errorf("%v %dx%d: read back\n%v\nexpected\n%v", format, size.X, size.Y, read.Cells, grey)
+			}
+		}
+		for _, format := range []Format{PBM, PlainPBM} {
+			if read := roundTrip(t, format, Pattern{Cells: bits}); !reflect.DeepEqual(read.Cells, bits) {
+				t.Errorf("%v %dx%d: read back\n%v\nexpected\n%v", format, size.X, size.Y, read.Cells, bits)
+			}
+		}
+	}
+}
+
+func TestReadPNM(t *testing.T) {
+	tests := []struct {
+		name  string
+		file  string
+		cells [][]uint8
+	}{
+		{
+			name:  "P2 with comments and a maxval of 15",
+			file:  "P2\n# made by GIMP\n3 # width\n2\n15\n0 15 # a comment among the cells\n7\n8 1 14\n",
+			cells: [][]uint8{{0, 255, 119}, {136, 17, 238}},
+		},
+		{
+			name:  "P2 with a maxval of 65535",
+			file:  "P2 2 1 65535 65535 127\n",
+			cells: [][]uint8{{255, 0}},
+		},
+		{
+			name:  "P5 with a maxval of 65535, two bytes a cell",
+			file:  "P5\n2 1\n65535\n\xff\xff\x80\x00",
+			cells: [][]uint8{{255, 128}},
+		},
+		{
+			name:  "P5 with a maxval of 1",
+			file:  "P5 3 1 1\n\x01\x00\x01",
+			cells: [][]uint8{{255, 0, 255}},
+		},
+		{
+			// the padding bits at the end of each row are set, and have to be ignored
+			name:  "P4 with row padding",
+			file:  "P4\n# comment\n10 2\n\xa0\x7f\x00\xc0",
+			cells: [][]uint8{{255, 0, 255, 0, 0, 0, 0, 0, 0, 255}, {0, 0, 0, 0, 0, 0, 0, 0, 255, 255}},
+		},
+		{
+			name:  "P1 without whitespace between the cells",
+			file:  "P1\n4 2\n0110\n1 0 0\n1",
+			cells: [][]uint8{{0, 255, 255, 0}, {255, 0, 0, 255}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p, err := Read(strings.NewReader(test.file), PGM, util.Rule{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(p.Cells, test.cells) {
+				t.Errorf("read\n%v\nexpected\n%v", p.Cells, test.cells)
+			}
+		})
+	}
+}
+
+func TestReadPNMErrors(t *testing.T) {
+	expectErrors(t, PGM, []string{
+		"",
+		"P",
+		"P3\n1 1\n255\n0 0 0\n",
+		"P6\n1 1\n255\n\x00\x00\x00",
+		"Q5\n1 1\n255\n\x00",
+		"P5\n3",
+		"P5\n3 x\n255\n\x00\x00\x00",
+		"P5\n3 1\n",
+		"P5\n3 1\n255",
+		"P5\n3 1\n255\n\x00\x00",
+		"P5\n3 1\n255x\x00\x00\x00",
+		"P5\n3 1\n0\n\x00\x00\x00",
+		"P5\n3 1\n70000\n\x00\x00\x00\x00\x00\x00",
+		"P5\n3 1\n15\n\x00\x10\x00",
+		"P5\n99999 99999\n255\n",
+		"P2\n3 1\n15\n0 16 0\n",
+		"P2\n3 1\n255\n0 1\n",
+		"P2\n3 1\n255\n0 a 1\n",
+		"P1\n3 1\n0 2 1\n",
+		"P1\n3 1\n0 1",
+		"P4\n10 2\n\xa0\x7f\x00",
+	})
+}
+
+func TestPNMSize(t *testing.T) {
+	// only the header is read, so the cells after it don't have to be there
+	width, height, err := Size(strings.NewReader("P5\n# comment\n40 30\n255\n"), PGM)
+	if err != nil || width != 40 || height != 30 {
+		t.Errorf("got %dx%d, %v, expected 40x30", width, height, err)
+	}
+}
@@ -0,0 +1,243 @@
+package pattern
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// the longest line written, as other readers expect
+const rleLineLength = 70
+
+// readRLE reads a run length encoded pattern: comment lines starting with #, a header line such as
+// x = 3, y = 3, rule = B3/S23, then runs of cells like 2bo3$ ending in !. Dead cells are b and alive
+// ones o, or with more states . for dead and A to X, then pA to yO, for states 1 to 255.
+func readRLE(r io.Reader, rule util.Rule) (Pattern, error) {
+	lines := bufio.NewScanner(r)
+	lines.Buffer(nil, 1<<20)
+
+	var p Pattern
+	width, height, err := readRLEStart(lines, &p.Rule)
+	if err != nil {
+		return p, err
+	}
+	if !p.Rule.IsZero() {
+		rule = p.Rule
+	}
+	rule = rule.OrConway()
+
+	p.Cells = make([][]uint8, height)
+	for y := range p.Cells {
+		p.Cells[y] = make([]uint8, width)
+	}
+	x, y, count, prefix := 0, 0, 0, 0
+	put := func(state int) error {
+		if count == 0 {
+			count = 1
+		}
+		if x+count > width || y >= height {
+			return fmt.Errorf("the cells go past the %dx%d the header gives", width, height)
+		}
+		if state >= rule.States {
+			return fmt.Errorf("state %d is past the %d states of %v", state, rule.States, rule)
+		}
+		for ; count > 0; count-- {
+			p.Cells[y][x] = rule.Level(state)
+			x++
+		}
+		return nil
+	}
+
+	for lines.Scan() {
+		for _, c := range lines.Text() {
+			var err error
+			switch {
+			case c >= '0' && c <= '9':
+				count = count*10 + int(c-'0')
+				if count > 1<<30 {
+					return p, fmt.Errorf("run of %d cells is far too long", count)
+				}
+				continue
+			case c == ' ' || c == '\t' || c == '\r':
+				continue
+			case c == '!':
+				return p, nil
+			case c == '$':
+				if count == 0 {
+					count = 1
+				}
+				x, y, count = 0, y+count, 0
+			case c == 'b' || c == '.':
+				if count == 0 {
+					count = 1
+				}
+				if x+count > width {
+					return p, fmt.Errorf("the cells go past the %dx%d the header gives", width, height)
+				}
+				x, count = x+count, 0
+			case c == 'o':
+				err = put(1)
+			case c >= 'A' && c <= 'X':
+				err = put(prefix*24 + int(c-'A') + 1)
+				prefix = 0
+			case c >= 'p' && c <= 'y':
+				prefix = int(c-'p') + 1
+				continue
+			default:
+				err = fmt.Errorf("unexpected %q in the cells", c)
+			}
+			if err != nil {
+				return p, err
+			}
+		}
+	}
+	if err := lines.Err(); err != nil {
+		return p, err
+	}
+	// plenty of files in the wild leave off the !
+	return p, nil
+}
+
+// readRLEStart reads the lines up to and including the header, giving the size the header gives and
+// setting the rule if the file gives one.
+func readRLEStart(lines *bufio.Scanner, rule *util.Rule) (width, height int, err error) {
+	width, height = -1, -1
+	for width < 0 && lines.Scan() {
+		line := strings.TrimSpace(lines.Text())
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#r"):
+			// the rule as old files give it, before the header had a place for it
+			if *rule, err = util.ParseRule(strings.TrimSpace(line[2:])); err != nil {
+				return -1, -1, err
+			}
+		case strings.HasPrefix(line, "#"):
+		default:
+			if width, height, err = readRLEHeader(line, rule); err != nil {
+				return -1, -1, err
+			}
+		}
+	}
+	if width < 0 {
+		if err := lines.Err(); err != nil {
+			return -1, -1, err
+		}
+		return -1, -1, fmt.Errorf("no x = ..., y = ... header line")
+	}
+	return width, height, nil
+}
+
+// readRLEHeader reads the header line's size, and its rule if it has one.
+func readRLEHeader(line string, rule *util.Rule) (width, height int, err error) {
+	// the rule goes last, as Larger than Life rules have commas of their own
+	size := line
+	if i := strings.Index(line, "rule"); i >= 0 {
+		size = line[:i]
+		value := strings.TrimSpace(line[i+len("rule"):])
+		if !strings.HasPrefix(value, "=") {
+			return -1, -1, fmt.Errorf("bad header line %q", line)
+		}
+		// Golly puts the size of a bounded grid after a colon, how the edges join up is left to whoever runs it
+		value = strings.SplitN(strings.TrimSpace(value[1:]), ":", 2)[0]
+		if *rule, err = util.ParseRule(value); err != nil {
+			return -1, -1, fmt.Errorf("bad header line %q: %v", line, err)
+		}
+	}
+
+	width, height = -1, -1
+	for _, part := range strings.Split(size, ",") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return -1, -1, fmt.Errorf("bad header line %q", line)
+		}
+		value, err := strconv.Atoi(strings.TrimSpace(kv[1]))
+		if err != nil {
+			return -1, -1, fmt.Errorf("bad header line %q: %v", line, err)
+		}
+		switch strings.TrimSpace(kv[0]) {
+		case "x":
+			width = value
+		case "y":
+			height = value
+		}
+	}
+	if width < 0 || height < 0 {
+		return -1, -1, fmt.Errorf("header line %q doesn't give x and y", line)
+	}
+	if width > maxCells || height > maxCells || width*height > maxCells {
+		return -1, -1, fmt.Errorf("%dx%d is too big a pattern", width, height)
+	}
+	return width, height, nil
+}
+
+// writeRLE writes the pattern run length encoded, with a header giving its size and rule.
+func writeRLE(w io.Writer, p Pattern) error {
+	out := bufio.NewWriter(w)
+	rule := p.Rule.OrConway()
+	fmt.Fprintf(out, "x = %d, y = %d, rule = %v\n", p.Width(), p.Height(), rule)
+
+	length := 0
+	run := func(n int, tag string) {
+		token := tag
+		if n > 1 {
+			token = strconv.Itoa(n) + tag
+		}
+		if length+len(token) > rleLineLength {
+			out.WriteString("\n")
+			length = 0
+		}
+		out.WriteString(token)
+		length += len(token)
+	}
+
+	ends := 0 // rows ended but not yet written, so trailing empty rows can be left off
+	for y, row := range p.Cells {
+		if y > 0 {
+			ends++
+		}
+		end := len(row)
+		for end > 0 && row[end-1] == 0 {
+			end--
+		}
+		if end == 0 {
+			continue
+		}
+		if ends > 0 {
+			run(ends, "$")
+			ends = 0
+		}
+		for x := 0; x < end; {
+			n := 1
+			for x+n < end && rule.State(row[x+n]) == rule.State(row[x]) {
+				n++
+			}
+			run(n, rleTag(rule, row[x]))
+			x += n
+		}
+	}
+	run(1, "!")
+	out.WriteString("\n")
+	return out.Flush()
+}
+
+// rleTag is how a cell at a grey level is written, b and o without dying states or letters numbering the states with them.
+func rleTag(rule util.Rule, level uint8) string {
+	state := rule.State(level)
+	if !rule.Generations() {
+		return string("bo"[state])
+	}
+	if state == 0 {
+		return "."
+	}
+	if state <= 24 {
+		return string(rune('A' + state - 1))
+	}
+	return string(rune('p'+(state-1)/24-1)) + string(rune('A'+(state-1)%24))
+}
@@ -0,0 +1,120 @@
+package pattern
+
+import (
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+func TestRLERoundTrip(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	// 256 states takes the two letter tags past X
+	for _, rule := range []util.Rule{util.Conway, util.MustParseRule("B36/S23"), util.MustParseRule("/2/3"), util.MustParseRule("B3/S23/256")} {
+		for _, size := range []util.Cell{{X: 1, Y: 1}, {X: 3, Y: 5}, {X: 100, Y: 7}} {
+			p := Pattern{Cells: randomCells(random, size.X, size.Y, rule), Rule: rule}
+			// dead rows and columns at the edges are left off, the header has to keep the size
+			p.Cells[size.Y-1] = make([]uint8, size.X)
+			p.Cells[0][size.X-1] = 0
+
+			read := roundTrip(t, RLE, p)
+			if read.Rule.String() != rule.String() {
+				t.Errorf("%v %dx%d: read back the rule %v", rule, size.X, size.Y, read.Rule)
+			}
+			if !reflect.DeepEqual(read.Cells, p.Cells) {
+				t.Errorf("%v %dx%d: read back\n%v\nexpected\n%v", rule, size.X, size.Y, read.Cells, p.Cells)
+			}
+		}
+	}
+}
+
+func TestReadRLE(t *testing.T) {
+	tests := []struct {
+		name  string
+		file  string
+		cells [][]uint8
+		rule  string
+	}{
+		{
+			name:  "glider",
+			file:  "#N Glider\n#C a comment\nx = 3, y = 3, rule = B3/S23\nbob$2bo$3o!\n",
+			cells: [][]uint8{{0, 255, 0}, {0, 0, 255}, {255, 255, 255}},
+			rule:  "B3/S23",
+		},
+		{
+			name:  "runs across lines and blank rows",
+			file:  "x = 4, y = 3\n2o\n2b2$\n4o!",
+			cells: [][]uint8{{255, 255, 0, 0}, {0, 0, 0, 0}, {255, 255, 255, 255}},
+		},
+		{
+			name:  "rule on an old style #r line",
+			file:  "#r 23/36\nx = 2, y = 1\nbo!",
+			cells: [][]uint8{{0, 255}},
+			rule:  "B36/S23",
+		},
+		{
+			name:  "Golly's bounded grid after the rule",
+			file:  "x = 1, y = 1, rule = B3/S23:T10,10\no!",
+			cells: [][]uint8{{255}},
+			rule:  "B3/S23",
+		},
+		{
+			name:  "cut short without the !",
+			file:  "x = 3, y = 2\n3o$o",
+			cells: [][]uint8{{255, 255, 255}, {255, 0, 0}},
+		},
+		{
+			name:  "Generations states",
+			file:  "x = 3, y = 1, rule = /2/3\nA.B!",
+			cells: [][]uint8{{255, 0, 128}},
+			rule:  "B2/S/C3",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p, err := Read(strings.NewReader(test.file), RLE, util.Rule{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(p.Cells, test.cells) {
+				t.Errorf("read\n%v\nexpected\n%v", p.Cells, test.cells)
+			}
+			if test.rule != "" && p.Rule.String() != test.rule {
+				t.Errorf("read the rule %v, expected %v", p.Rule, test.rule)
+			}
+		})
+	}
+}
+
+func TestReadRLEErrors(t *testing.T) {
+	expectErrors(t, RLE, []string{
+		"",
+		"#C nothing but a comment\n",
+		"x = 3, y",
+		"x = 3, y = 3, ru",
+		"x = 3\nbo!",
+		"x = a, y = 2\nbo!",
+		"x = -1, y = 2\nbo!",
+		"x = 100000, y = 100000\nbo!",
+		"x = 3, y = 1, rule = B9/S23\nbo!",
+		"x = 3, y = 1, rule B3/S23\nbo!",
+		"#r B3/S2a\nx = 3, y = 1\nbo!",
+		"x = 3, y = 1\n4o!",
+		"x = 3, y = 1\n2b2o!",
+		"x = 3, y = 1\n4b!",
+		"x = 3, y = 1\no$o!",
+		"x = 3, y = 1\n99999999999o!",
+		"x = 3, y = 1\nbzo!",
+		"x = 3, y = 1, rule = /2/3\nC!",
+	})
+}
+
+func TestRLESize(t *testing.T) {
+	// only the header is read, so the cells after it don't have to fit
+	width, height, err := Size(strings.NewReader("#C big\nx = 40, y = 30, rule = B3/S23\nthe cells aren't read"), RLE)
+	if err != nil || width != 40 || height != 30 {
+		t.Errorf("got %dx%d, %v, expected 40x30", width, height, err)
+	}
+}
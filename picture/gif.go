@@ -0,0 +1,84 @@
+package picture
+
+import (
+	"image"
+	"image/gif"
+	"io"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// Recording is an animated GIF of a run, put together a turn at a time. Each frame only keeps the
+// part of the picture that changed since the one before, drawn over it, so long runs of a mostly
+// settled world stay small.
+type Recording struct {
+	style Style
+	rule  util.Rule
+	delay int // hundredths of a second each turn added is shown for
+	gif   gif.GIF
+	last  *image.Paletted // the whole picture as of the last turn added
+}
+
+// NewRecording starts an empty recording, showing each turn added for delay hundredths of a second.
+func NewRecording(rule util.Rule, style Style, delay int) *Recording {
+	return &Recording{style: style, rule: rule, delay: delay}
+}
+
+// Add adds a world of grey levels as the next frame. A world the same as the last one added just
+// shows the last frame for longer.
+func (r *Recording) Add(world [][]uint8) {
+	picture := r.style.Draw(world, r.rule)
+	if r.last == nil {
+		r.gif.Image = append(r.gif.Image, picture)
+		r.gif.Delay = append(r.gif.Delay, r.delay)
+		r.gif.Config = image.Config{ColorModel: picture.Palette, Width: picture.Rect.Dx(), Height: picture.Rect.Dy()}
+		r.last = picture
+		return
+	}
+
+	changed := changes(r.last, picture)
+	r.last = picture
+	if changed.Empty() {
+		r.gif.Delay[len(r.gif.Delay)-1] += r.delay
+		return
+	}
+	// copied out so the frame doesn't hold on to the whole picture
+	frame := image.NewPaletted(changed, picture.Palette)
+	for y := changed.Min.Y; y < changed.Max.Y; y++ {
+		copy(frame.Pix[frame.PixOffset(changed.Min.X, y):], picture.Pix[picture.PixOffset(changed.Min.X, y):picture.PixOffset(changed.Max.X, y)])
+	}
+	r.gif.Image = append(r.gif.Image, frame)
+	r.gif.Delay = append(r.gif.Delay, r.delay)
+}
+
+// Frames is how many frames the recording has, which can be fewer than the turns added.
+func (r *Recording) Frames() int {
+	return len(r.gif.Image)
+}
+
+// WriteGIF writes the recording out as an animated GIF that loops forever.
+func (r *Recording) WriteGIF(w io.Writer) error {
+	return gif.EncodeAll(w, &r.gif)
+}
+
+// changes is the smallest rectangle around every pixel that differs between two pictures the same size.
+func changes(before, after *image.Paletted) image.Rectangle {
+	var changed image.Rectangle
+	bounds := after.Rect
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		row := after.PixOffset(bounds.Min.X, y)
+		left, right := -1, -1
+		for x := 0; x < bounds.Dx(); x++ {
+			if before.Pix[row+x] != after.Pix[row+x] {
+				if left < 0 {
+					left = x
+				}
+				right = x
+			}
+		}
+		if left >= 0 {
+			changed = changed.Union(image.Rect(bounds.Min.X+left, y, bounds.Min.X+right+1, y+1))
+		}
+	}
+	return changed
+}
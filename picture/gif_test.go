@@ -0,0 +1,102 @@
+package picture
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"reflect"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// blinker is a world with a blinker in the middle, across if across is set, otherwise down.
+func blinker(across bool) [][]uint8 {
+	world := make([][]uint8, 5)
+	for y := range world {
+		world[y] = make([]uint8, 5)
+	}
+	for i := 1; i < 4; i++ {
+		if across {
+			world[2][i] = 255
+		} else {
+			world[i][2] = 255
+		}
+	}
+	return world
+}
+
+func TestRecording(t *testing.T) {
+	const scale, delay = 2, 7
+	style := Style{Scale: scale, Palette: Palette{Dead: color.RGBA{10, 20, 30, 255}, Alive: color.RGBA{240, 230, 220, 255}}}
+	across, down := blinker(true), blinker(false)
+	// the same world twice in a row makes the frame before last longer rather than adding one
+	worlds := [][][]uint8{across, across, down, across, across, across, down}
+	expectedDelays := []int{2 * delay, delay, 3 * delay, delay}
+
+	recording := NewRecording(util.Conway, style, delay)
+	for _, world := range worlds {
+		recording.Add(world)
+	}
+	if recording.Frames() != len(expectedDelays) {
+		t.Fatalf("%d frames, expected %d", recording.Frames(), len(expectedDelays))
+	}
+
+	var b bytes.Buffer
+	if err := recording.WriteGIF(&b); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := gif.DecodeAll(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded.Image) != len(expectedDelays) || !reflect.DeepEqual(decoded.Delay, expectedDelays) {
+		t.Fatalf("%d frames with delays %v, expected %d with %v", len(decoded.Image), decoded.Delay, len(expectedDelays), expectedDelays)
+	}
+	if decoded.Config.Width != 5*scale || decoded.Config.Height != 5*scale {
+		t.Errorf("the GIF is %dx%d, expected %dx%d", decoded.Config.Width, decoded.Config.Height, 5*scale, 5*scale)
+	}
+
+	// only the first frame is the whole picture, the blinker turning only changes the 3x3 around it
+	changed := image.Rect(1*scale, 1*scale, 4*scale, 4*scale)
+	for i, frame := range decoded.Image[1:] {
+		if frame.Bounds() != changed {
+			t.Errorf("frame %d covers %v, expected %v", i+1, frame.Bounds(), changed)
+		}
+	}
+
+	// each frame drawn over the ones before comes out as the world it was added for
+	shown := []int{0, 2, 3, 6}
+	canvas := image.NewRGBA(image.Rect(0, 0, 5*scale, 5*scale))
+	for i, frame := range decoded.Image {
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Src)
+		expected := style.Draw(worlds[shown[i]], util.Conway)
+		for y := 0; y < 5*scale; y++ {
+			for x := 0; x < 5*scale; x++ {
+				if got, want := color.RGBAModel.Convert(canvas.At(x, y)), color.RGBAModel.Convert(expected.At(x, y)); got != want {
+					t.Fatalf("frame %d: pixel %d,%d is %v, expected %v", i, x, y, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestRecordingStill(t *testing.T) {
+	recording := NewRecording(util.Conway, Style{}, 5)
+	for i := 0; i < 10; i++ {
+		recording.Add(blinker(true))
+	}
+	var b bytes.Buffer
+	if err := recording.WriteGIF(&b); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := gif.DecodeAll(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded.Image) != 1 || !reflect.DeepEqual(decoded.Delay, []int{50}) {
+		t.Errorf("%d frames with delays %v, expected one shown for 50", len(decoded.Image), decoded.Delay)
+	}
+}
@@ -0,0 +1,148 @@
+// Package picture draws worlds as pictures to share: PNG snapshots, and animated GIF recordings of a run.
+package picture
+
+import (
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"strings"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// Palette is the colours cells are drawn in. Dying cells fade from Alive to Dead as they die.
+// The zero Palette stands for Mono, the same white on black as the worlds' greymaps.
+type Palette struct {
+	Dead, Alive color.RGBA
+}
+
+var palettes = map[string]Palette{
+	"mono":   {Dead: color.RGBA{0, 0, 0, 255}, Alive: color.RGBA{255, 255, 255, 255}},
+	"paper":  {Dead: color.RGBA{255, 255, 255, 255}, Alive: color.RGBA{0, 0, 0, 255}},
+	"matrix": {Dead: color.RGBA{0, 0, 0, 255}, Alive: color.RGBA{0, 255, 70, 255}},
+	"ember":  {Dead: color.RGBA{20, 0, 40, 255}, Alive: color.RGBA{255, 200, 40, 255}},
+}
+
+// Mono is white alive cells on black.
+var Mono = palettes["mono"]
+
+// ParsePalette reads a palette by its name, mono, paper, matrix or ember, or as the dead and alive colours
+// in hex, as in #ffffff,#000000.
+func ParsePalette(s string) (Palette, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if palette, ok := palettes[s]; ok {
+		return palette, nil
+	}
+
+	colours := strings.Split(s, ",")
+	if len(colours) != 2 {
+		return Palette{}, fmt.Errorf("unknown palette %q, expected one of mono, paper, matrix or ember, or two colours like #000000,#ffffff", s)
+	}
+	var palette Palette
+	for i, colour := range []*color.RGBA{&palette.Dead, &palette.Alive} {
+		c := strings.TrimPrefix(strings.TrimSpace(colours[i]), "#")
+		// not Sscanf, which stops at the first character that isn't hex and takes what it read so far
+		rgb, err := hex.DecodeString(c)
+		if err != nil || len(rgb) != 3 {
+			return Palette{}, fmt.Errorf("bad colour %q in palette %q, expected something like #ff8800", colours[i], s)
+		}
+		*colour = color.RGBA{rgb[0], rgb[1], rgb[2], 255}
+	}
+	return palette, nil
+}
+
+// OrMono gives back the palette, or Mono if it was never set.
+func (p Palette) OrMono() Palette {
+	if p == (Palette{}) {
+		return Mono
+	}
+	return p
+}
+
+func (p Palette) String() string {
+	p = p.OrMono()
+	for name, palette := range palettes {
+		if palette == p {
+			return name
+		}
+	}
+	return fmt.Sprintf("#%02x%02x%02x,#%02x%02x%02x", p.Dead.R, p.Dead.G, p.Dead.B, p.Alive.R, p.Alive.G, p.Alive.B)
+}
+
+// Set parses a palette into p, so it can be given as a flag.
+func (p *Palette) Set(s string) (err error) {
+	*p, err = ParsePalette(s)
+	return
+}
+
+// Style is how worlds are drawn.
+type Style struct {
+	Scale   int // pixels a side each cell is drawn as, 0 is the same as 1
+	Palette Palette
+}
+
+func (s Style) scale() int {
+	if s.Scale < 1 {
+		return 1
+	}
+	return s.Scale
+}
+
+// Colours is a colour for each state of the rule's cells, numbered as util.Rule.State numbers them.
+func (s Style) Colours(rule util.Rule) color.Palette {
+	palette := s.Palette.OrMono()
+	rule = rule.OrConway()
+	states := 2
+	if rule.Generations() {
+		states = rule.States
+	}
+
+	colours := color.Palette{palette.Dead, palette.Alive}
+	// the dying states fade evenly from alive to dead, the last one a step away from dead
+	fade := func(alive, dead uint8, state int) uint8 {
+		return uint8(int(alive) + (int(dead)-int(alive))*(state-1)/(states-1))
+	}
+	for state := 2; state < states; state++ {
+		colours = append(colours, color.RGBA{
+			fade(palette.Alive.R, palette.Dead.R, state),
+			fade(palette.Alive.G, palette.Dead.G, state),
+			fade(palette.Alive.B, palette.Dead.B, state),
+			255,
+		})
+	}
+	return colours
+}
+
+// Draw draws a world of grey levels under a rule.
+func (s Style) Draw(world [][]uint8, rule util.Rule) *image.Paletted {
+	rule = rule.OrConway()
+	scale := s.scale()
+	height, width := len(world), 0
+	if height > 0 {
+		width = len(world[0])
+	}
+
+	picture := image.NewPaletted(image.Rect(0, 0, width*scale, height*scale), s.Colours(rule))
+	for y, row := range world {
+		line := picture.Pix[y*scale*picture.Stride : y*scale*picture.Stride+width*scale]
+		for x, level := range row {
+			state := uint8(rule.State(level))
+			for i := 0; i < scale; i++ {
+				line[x*scale+i] = state
+			}
+		}
+		// every row of pixels for a row of cells is the same as the first
+		for i := 1; i < scale; i++ {
+			copy(picture.Pix[(y*scale+i)*picture.Stride:], line)
+		}
+	}
+	return picture
+}
+
+// WritePNG writes a PNG picture of a world of grey levels under a rule.
+func WritePNG(w io.Writer, world [][]uint8, rule util.Rule, style Style) error {
+	return png.Encode(w, style.Draw(world, rule))
+}
@@ -0,0 +1,127 @@
+package picture
+
+import (
+	"image/color"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+func TestParsePalette(t *testing.T) {
+	tests := []struct {
+		s       string
+		palette Palette
+	}{
+		{"mono", Mono},
+		{" Paper ", palettes["paper"]},
+		{"#102030,#a0B0c0", Palette{Dead: color.RGBA{0x10, 0x20, 0x30, 255}, Alive: color.RGBA{0xa0, 0xb0, 0xc0, 255}}},
+		// the # is optional
+		{"102030, 405060", Palette{Dead: color.RGBA{0x10, 0x20, 0x30, 255}, Alive: color.RGBA{0x40, 0x50, 0x60, 255}}},
+	}
+	for _, test := range tests {
+		palette, err := ParsePalette(test.s)
+		if err != nil || palette != test.palette {
+			t.Errorf("%q: parsed as %v, %v, expected %v", test.s, palette, err, test.palette)
+			continue
+		}
+		if again, err := ParsePalette(palette.String()); err != nil || again != palette {
+			t.Errorf("%q: %q parsed back as %v, %v", test.s, palette.String(), again, err)
+		}
+	}
+	// a palette given in hex that's the same as a named one goes by its name
+	if s := (Palette{Dead: color.RGBA{255, 255, 255, 255}, Alive: color.RGBA{0, 0, 0, 255}}).String(); s != "paper" {
+		t.Errorf("white on black is %q, expected paper", s)
+	}
+	if s := (Palette{}).String(); s != "mono" {
+		t.Errorf("the zero palette is %q, expected mono", s)
+	}
+}
+
+func TestParsePaletteErrors(t *testing.T) {
+	for _, s := range []string{
+		"",
+		"rainbow",
+		"#000000",
+		"#000000,",
+		"#000000,#ffffff,#ff0000",
+		"#zzzzzz,#ffffff",
+		"#00000g,#ffffff",
+		"#12345,#ffffff",
+		"#000000,#1234567",
+		"#0x1234,#ffffff",
+		"##000000,#ffffff",
+	} {
+		if palette, err := ParsePalette(s); err == nil {
+			t.Errorf("%q: expected an error, parsed as %v", s, palette)
+		}
+	}
+}
+
+func TestColours(t *testing.T) {
+	style := Style{Palette: Palette{Dead: color.RGBA{0, 0, 0, 255}, Alive: color.RGBA{200, 100, 40, 255}}}
+	if colours := style.Colours(util.Conway); len(colours) != 2 {
+		t.Errorf("Conway has %d colours, expected 2", len(colours))
+	}
+
+	colours := style.Colours(util.MustParseRule("/2/5"))
+	expected := color.Palette{
+		color.RGBA{0, 0, 0, 255},
+		color.RGBA{200, 100, 40, 255},
+		color.RGBA{150, 75, 30, 255},
+		color.RGBA{100, 50, 20, 255},
+		color.RGBA{50, 25, 10, 255},
+	}
+	if len(colours) != len(expected) {
+		t.Fatalf("got %d colours, expected %d", len(colours), len(expected))
+	}
+	for state := range expected {
+		if colours[state] != expected[state] {
+			t.Errorf("state %d is %v, expected %v", state, colours[state], expected[state])
+		}
+	}
+}
+
+func TestDraw(t *testing.T) {
+	rule := util.MustParseRule("/2/3")
+	dying := rule.Level(2)
+	world := [][]uint8{
+		{255, 0, dying},
+		{0, dying, 255},
+	}
+	palette := Palette{Dead: color.RGBA{0, 0, 100, 255}, Alive: color.RGBA{200, 200, 200, 255}}
+	dyingColour := color.RGBA{100, 100, 150, 255}
+
+	for _, scale := range []int{0, 1, 3} {
+		picture := Style{Scale: scale, Palette: palette}.Draw(world, rule)
+		side := scale
+		if side < 1 {
+			side = 1
+		}
+		if bounds := picture.Bounds(); bounds.Dx() != 3*side || bounds.Dy() != 2*side {
+			t.Fatalf("scale %d: the picture is %dx%d, expected %dx%d", scale, bounds.Dx(), bounds.Dy(), 3*side, 2*side)
+		}
+		// every pixel is the colour of the cell it's part of
+		for y := 0; y < 2*side; y++ {
+			for x := 0; x < 3*side; x++ {
+				var expected color.Color
+				switch level := world[y/side][x/side]; level {
+				case 255:
+					expected = palette.Alive
+				case 0:
+					expected = palette.Dead
+				default:
+					expected = dyingColour
+				}
+				if got := picture.At(x, y); got != expected {
+					t.Errorf("scale %d: pixel %d,%d is %v, expected %v", scale, x, y, got, expected)
+				}
+			}
+		}
+	}
+}
+
+func TestDrawEmpty(t *testing.T) {
+	if picture := (Style{Scale: 4}).Draw(nil, util.Conway); !picture.Bounds().Empty() {
+		t.Errorf("an empty world drew as %v", picture.Bounds())
+	}
+}
@@ -36,6 +36,8 @@ sdlLoop:
 			switch e := event.(type) { //sees underlying type and acts on that
 			case gol.CellFlipped:
 				w.FlipPixel(e.Cell.X, e.Cell.Y)
+			case gol.CellStateChanged:
+				w.SetLevel(e.Cell.X, e.Cell.Y, e.Level)
 			case gol.TurnComplete:
 				w.RenderFrame()
 			case gol.FinalTurnComplete:
@@ -87,6 +87,24 @@ func (w *Window) FlipPixel(x, y int) {
 	w.pixels[4*(y*width+x)+3] = ^w.pixels[4*(y*width+x)+3]
 }
 
+// SetLevel colours a pixel by a cell's grey level, alive cells are white and dying ones
+// fade from yellow through red to black as they get closer to dead.
+func (w *Window) SetLevel(x, y int, level uint8) {
+	if x < 0 || y < 0 || x >= int(w.Width) || y >= int(w.Height) {
+		panic(fmt.Sprintf("CellStateChanged event at (%d, %d) is outside the bounds of the window.", x, y))
+	}
+
+	width := int(w.Width)
+	b, g, r := level, level, level
+	if level != 0 && level != 0xFF {
+		b, g, r = 0, uint8(int(level)*int(level)/0xFF), level
+	}
+	w.pixels[4*(y*width+x)+0] = b
+	w.pixels[4*(y*width+x)+1] = g
+	w.pixels[4*(y*width+x)+2] = r
+	w.pixels[4*(y*width+x)+3] = 0xFF
+}
+
 func (w *Window) CountPixels() int {
 	count := 0
 	for i := 0; i < int(w.Width) * int(w.Height) * 4; i += 4 {
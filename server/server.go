@@ -1,27 +1,43 @@
 package main
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
+	"math/bits"
 	_ "math/rand"
 	"net"
 	"net/rpc"
+	"os"
+	"os/signal"
+	"runtime"
+	"strconv"
 	"sync"
+	"syscall"
+	"time"
 	"uk.ac.bris.cs/gameoflife/gol/stubs"
 	"uk.ac.bris.cs/gameoflife/util"
 )
 
 var kill = make(chan bool, 1)
 var runningCalls = sync.WaitGroup{}
+var defaultThreads = 1 //goroutines per worker when the broker doesn't ask for a number
+
+const haloTimeout = 10 * time.Second //a neighbour that takes longer than this is presumed dead, the broker sorts it out
+const registerRetry = 1 * time.Second
+const reregisterAfter = 5 * time.Second //without a heartbeat for this long the broker may have restarted and forgotten us
+const tileSize = 64 //cells a side of the tiles the strip is tracked in, a word of cells when packed a bit a cell
 
 // helpers
 
-func updateState(isAlive bool, neighbours int) bool {
-	return isAlive && neighbours > 1 && neighbours < 4 || !isAlive && neighbours == 3
+//gives the cell's grey level next turn, which is only ever 0 or 255 unless the rule has dying states
+func updateState(rule util.Rule, level uint8, neighbours int) uint8 {
+	return rule.Step(level, neighbours)
 }
 
-func isAlive(x int, y int, world [][]byte) bool {
-	return world[y][x] != 0
+//aliveFrom is the lowest grey level the rule counts as alive
+func isAlive(x int, y int, world [][]byte, aliveFrom uint8) bool {
+	return world[y][x] >= aliveFrom
 }
 
 //creates a 2D slice of a world of size height x width
@@ -38,107 +54,434 @@ func genWorldBlock(height int, width int) [][]byte {
 
 // logic engine
 
-func countLiveNeighbours(p stubs.Params, x int, y int, world [][]byte) int {
+//counts the eight neighbours around a cell within a strip that carries a halo row above and below it
+//the halo rows stand in for the rest of the world, beside looks up the cells past the left and right edges
+func countLiveNeighbours(p stubs.Params, x int, y int, strip [][]byte, beside func(x int, y int) bool) int {
 		liveNeighbours := 0
+		aliveFrom := p.Rule.AliveFrom()
 
 		w := p.ImageWidth - 1
-		h := p.ImageHeight - 1
 
 		l := x - 1
 		r := x + 1
 		u := y + 1
 		d := y - 1
 
-		if l < 0 {l = w}
-		if r > w {r = 0}
-		if u > h {u = 0}
-		if d < 0 {d = h}
+		if isAlive(x, u, strip, aliveFrom) { liveNeighbours += 1}
+		if isAlive(x, d, strip, aliveFrom) { liveNeighbours += 1}
+
+		if l < 0 {
+			if beside(l, u) { liveNeighbours += 1}
+			if beside(l, d) { liveNeighbours += 1}
+			if beside(l, y) { liveNeighbours += 1}
+		} else {
+			if isAlive(l, u, strip, aliveFrom) { liveNeighbours += 1}
+			if isAlive(l, d, strip, aliveFrom) { liveNeighbours += 1}
+			if isAlive(l, y, strip, aliveFrom) { liveNeighbours += 1}
+		}
 
-		if isAlive(x, u, world) { liveNeighbours += 1}
-		if isAlive(x, d, world) { liveNeighbours += 1}
-		if isAlive(l, u, world) { liveNeighbours += 1}
-		if isAlive(r, u, world) { liveNeighbours += 1}
-		if isAlive(l, d, world) { liveNeighbours += 1}
-		if isAlive(r, d, world) { liveNeighbours += 1}
-		if isAlive(l, y, world) { liveNeighbours += 1}
-		if isAlive(r, y, world) { liveNeighbours += 1}
+		if r > w {
+			if beside(r, u) { liveNeighbours += 1}
+			if beside(r, d) { liveNeighbours += 1}
+			if beside(r, y) { liveNeighbours += 1}
+		} else {
+			if isAlive(r, u, strip, aliveFrom) { liveNeighbours += 1}
+			if isAlive(r, d, strip, aliveFrom) { liveNeighbours += 1}
+			if isAlive(r, y, strip, aliveFrom) { liveNeighbours += 1}
+		}
 
 		return liveNeighbours
 	}
 
-func calculateNextState(g *Gol, p stubs.Params, /*c distributorChannels, */world [][]byte, y1 int, y2 int, turn int) {
+//counts neighbours at any of the offsets, for neighbourhoods other than the eight cells around
+//the strip carries as many halo rows as the offsets reach
+func countNeighbourhood(p stubs.Params, offsets []util.Cell, x int, y int, strip [][]byte, beside func(x int, y int) bool) int {
+	liveNeighbours := 0
+	aliveFrom := p.Rule.AliveFrom()
+
+	for _, offset := range offsets {
+		nx, ny := x+offset.X, y+offset.Y
+		if nx < 0 || nx >= p.ImageWidth {
+			if beside(nx, ny) { liveNeighbours += 1}
+		} else if isAlive(nx, ny, strip, aliveFrom) {
+			liveNeighbours += 1
+		}
+	}
+
+	return liveNeighbours
+}
 
-	height := y2 - y1
+//writes the next state of rows y1 to y2 (strip coordinates) into next
+//cells that active says can't have changed are copied across rather than worked out
+func calculateNextState(p stubs.Params, strip [][]byte, next [][]byte, y1 int, y2 int, beside func(x int, y int) bool, active func(x int, y int) bool) {
+	var offsets []util.Cell
+	if !p.Rule.Classic() {
+		offsets = p.Rule.Offsets()
+	}
 
-	g.Mut.Lock(); defer g.Mut.Unlock()
 	for x := 0; x < p.ImageWidth; x++ {
+		for y := y1; y < y2; y++ {
+			if !active(x, y) {
+				next[y][x] = strip[y][x]
+				continue
+			}
+			var neighbours int
+			if offsets == nil {
+				neighbours = countLiveNeighbours(p, x, y, strip, beside)
+			} else {
+				neighbours = countNeighbourhood(p, offsets, x, y, strip, beside)
+			}
+			next[y][x] = updateState(p.Rule, strip[y][x], neighbours)
+		}
+	}
+}
+
+//splits the non-halo rows of the strip between the worker's threads and waits for them all
+func (g *Gol) stepStrip() {
+	height := g.Slice.To - g.Slice.From
+	threads := g.Threads
+	if threads > height { threads = height }
+	if threads < 1 { threads = 1 }
+
+	splitSize := height / threads
+	extraRows := height % threads
+
+	//two state rules over the eight cells around are stepped a word of 64 cells at a time
+	//anything else is stepped a cell at a time on grey levels
+	var step func(y1 int, y2 int)
+	finish := func() {}
+	switch {
+	case !g.Strip.Grey && g.Params.Rule.Classic():
+		step = g.bitsStepper()
+	case g.Strip.Grey:
+		strip, next := g.Strip.LevelRows(), g.Next.LevelRows()
+		step = func(y1 int, y2 int) { calculateNextState(g.Params, strip, next, y1, y2, g.besideStrip, g.isActive) }
+	default:
+		//bigger neighbourhoods are counted cell by cell, so the bits are unpacked for the turn
+		strip, next := g.Strip.Unpack(), genWorldBlock(g.Strip.Height, g.Params.ImageWidth)
+		step = func(y1 int, y2 int) { calculateNextState(g.Params, strip, next, y1, y2, g.besideStrip, g.isActive) }
+		finish = func() { g.Next = util.Pack(next, false) }
+	}
+
+	var wg sync.WaitGroup
+	y1 := g.Slice.Halo
+	for thread := 0; thread < threads; thread++ {
+		y2 := y1 + splitSize
+		if thread < extraRows { y2++ }
+
+		wg.Add(1)
+		go func(y1 int, y2 int){
+			defer wg.Done()
+			step(y1, y2)
+		}(y1, y2)
+		y1 = y2
+	}
+	wg.Wait()
+	finish()
+}
+
+//steps rows of a strip packed a bit a cell, working out 64 cells at once
+//the cells past the left and right edges of each row are looked up before any rows are stepped
+func (g *Gol) bitsStepper() func(y1 int, y2 int) {
+	strip, next := g.Strip, g.Next
+	width := g.Params.ImageWidth
+	left := make([]uint64, strip.Height)
+	right := make([]uint64, strip.Height)
+	for y := 0; y < strip.Height; y++ {
+		if g.besideStrip(-1, y) { left[y] = 1 }
+		if g.besideStrip(width, y) { right[y] = 1 }
+	}
+
+	rule := g.Params.Rule
+	stride := strip.Stride()
+	active, across, halo := g.Active, g.tilesAcross(), g.Slice.Halo
+	lastBit := uint((width - 1) % 64)
+	lastMask := ^uint64(0) >> (63 - lastBit) //clears the bits past the end of a row
+
+	return func(y1 int, y2 int) {
+		var around [8]uint64
+		for y := y1; y < y2; y++ {
+			rows := [3][]uint64{strip.Row(y-1), strip.Row(y), strip.Row(y+1)}
+			out := next.Row(y)
+			tiles := active[(y-halo)/tileSize*across:]
+			for i := 0; i < stride; i++ {
+				//a tile is a word across, so a quiet tile's word stays as it is
+				if !tiles[i] {
+					out[i] = rows[1][i]
+					continue
+				}
+				n := 0
+				for k, row := range rows {
+					//each cell's west neighbour lines up with it by shifting towards the higher bits
+					west := row[i] << 1
+					if i > 0 { west |= row[i-1] >> 63 } else { west |= left[y-1+k] }
+					east := row[i] >> 1
+					if i < stride-1 { east |= row[i+1] << 63 } else { east |= right[y-1+k] << lastBit }
+
+					around[n], around[n+1] = west, east
+					n += 2
+					if k != 1 {
+						around[n] = row[i]
+						n++
+					}
+				}
+				out[i] = rule.StepBits(rows[1][i], around)
+			}
+			out[stride-1] &= lastMask
+		}
+	}
+}
+
+//whether the cell at column x, past the left or right edge of the world, in strip row y is alive
+//the topology says where it is, or that it's dead, must be called with g.Mut held
+func (g *Gol) besideStrip(x int, y int) bool {
+	aliveFrom := g.Params.Rule.AliveFrom()
+	topology := g.Params.Topology
+	width, height := g.Params.ImageWidth, g.Params.ImageHeight
+
+	if !topology.TwistsSides() {
+		//the same row on the other side, halos and all, so only x moves
+		wx, _, ok := topology.Wrap(x, 0, width, height)
+		return ok && g.Strip.Get(wx, y) >= aliveFrom
+	}
+
+	//y is mirrored so the cell is in someone else's rows, the broker hands us the edge columns for this
+	wx, wy, ok := topology.Wrap(x, g.Slice.From+y-g.Slice.Halo, width, height)
+	if !ok { return false }
+	if wx < len(g.Edges.Left) {
+		return g.Edges.Left[wx][wy] >= aliveFrom
+	}
+	return g.Edges.Right[width-1-wx][wy] >= aliveFrom
+}
+
+//our rows of the world's first and last few columns, as many as the halo, must be called with g.Mut held
+func (g *Gol) edgeColumns() stubs.Edges {
+	height := g.Slice.To - g.Slice.From
+	columns := g.Slice.Halo
+	if columns > g.Params.ImageWidth { columns = g.Params.ImageWidth }
+
+	edges := stubs.Edges{Left: make([][]uint8, columns), Right: make([][]uint8, columns)}
+	for i := 0; i < columns; i++ {
+		edges.Left[i] = make([]uint8, height)
+		edges.Right[i] = make([]uint8, height)
 		for y := 0; y < height; y++ {
-			yWorld := y + y1
-			neighbours := countLiveNeighbours(p, x, yWorld, world)
-			alive := isAlive(x, yWorld, world)
-			alive = updateState(alive, neighbours)
+			edges.Left[i][y] = g.Strip.Get(i, y+g.Slice.Halo)
+			edges.Right[i][y] = g.Strip.Get(g.Params.ImageWidth-1-i, y+g.Slice.Halo)
+		}
+	}
+	return edges
+}
 
-			if alive {
-				g.Strip[y][x] = 255
-			} else {
-				g.Strip[y][x] = 0
+func (g *Gol) tilesAcross() int {
+	return (g.Params.ImageWidth + tileSize - 1) / tileSize
+}
+
+func (g *Gol) tilesDown() int {
+	return (g.Slice.To - g.Slice.From + tileSize - 1) / tileSize
+}
+
+//whether the cell at x, strip row y is in a tile that needs working out this turn
+func (g *Gol) isActive(x int, y int) bool {
+	return g.Active[(y-g.Slice.Halo)/tileSize*g.tilesAcross()+x/tileSize]
+}
+
+func (g *Gol) wrapsSides() bool {
+	_, _, ok := g.Params.Topology.Wrap(-1, 0, g.Params.ImageWidth, g.Params.ImageHeight)
+	return ok && !g.Params.Topology.TwistsSides()
+}
+
+//marks every tile with a cell within reach of the cells from x0, y0 up to x1, y1, in the strip's own rows
+//going round the sides when the topology joins them without a twist, must be called with g.Mut held
+func (g *Gol) markAround(x0 int, y0 int, x1 int, y1 int) {
+	reach := g.Params.Rule.Reach()
+	width, height := g.Params.ImageWidth, g.Slice.To-g.Slice.From
+	x0, y0, x1, y1 = x0-reach, y0-reach, x1+reach, y1+reach
+	if y0 < 0 { y0 = 0 }
+	if y1 > height { y1 = height }
+
+	spans := [][2]int{{x0, x1}}
+	if g.wrapsSides() {
+		switch {
+		case x1-x0 >= width:
+			spans = [][2]int{{0, width}}
+		case x0 < 0:
+			spans = [][2]int{{x0 + width, width}, {0, x1}}
+		case x1 > width:
+			spans = [][2]int{{x0, width}, {0, x1 - width}}
+		}
+	}
+
+	across := g.tilesAcross()
+	for _, span := range spans {
+		from, to := span[0], span[1]
+		if from < 0 { from = 0 }
+		if to > width { to = width }
+		for ty := y0 / tileSize; y0 < y1 && ty <= (y1-1)/tileSize; ty++ {
+			for tx := from / tileSize; from < to && tx <= (to-1)/tileSize; tx++ {
+				g.Active[ty*across+tx] = true
 			}
 		}
 	}
 }
 
-func (g *Gol) calculateAliveCells(p stubs.Params, world [][]byte) []util.Cell {
-	var cells []util.Cell
+//marks the tiles around a tile
+func (g *Gol) markTile(tx int, ty int) {
+	x1, y1 := (tx+1)*tileSize, (ty+1)*tileSize
+	if x1 > g.Params.ImageWidth { x1 = g.Params.ImageWidth }
+	if y1 > g.Slice.To-g.Slice.From { y1 = g.Slice.To-g.Slice.From }
+	g.markAround(tx*tileSize, ty*tileSize, x1, y1)
+}
+
+//whether row ya of a and row yb of b differ anywhere in tile column tx
+func tileDiffers(a util.Packed, ya int, b util.Packed, yb int, tx int) bool {
+	if !a.Grey {
+		return a.Row(ya)[tx] != b.Row(yb)[tx]
+	}
+	x0, x1 := tx*tileSize, (tx+1)*tileSize
+	if x1 > a.Width { x1 = a.Width }
+	return !bytes.Equal(a.Levels[ya*a.Width+x0:ya*a.Width+x1], b.Levels[yb*b.Width+x0:yb*b.Width+x1])
+}
+
+//works out which tiles need working out next turn from the ones that changed this turn, cells further than
+//the rule reaches from any change have the same neighbours as last turn so come out the same again
+//must be called with g.Mut held, after stepping but before Strip and Next are swapped
+func (g *Gol) trackActive() {
+	across := g.tilesAcross()
+	height := g.Slice.To - g.Slice.From
+	changed := make([]bool, len(g.Active))
+	for y := 0; y < height; y++ {
+		for tx := 0; tx < across; tx++ {
+			t := y/tileSize*across + tx
+			if g.Active[t] && !changed[t] && tileDiffers(g.Strip, y+g.Slice.Halo, g.Next, y+g.Slice.Halo, tx) {
+				changed[t] = true
+			}
+		}
+	}
+
+	g.Active = make([]bool, len(changed))
+	for t, c := range changed {
+		if c { g.markTile(t%across, t/across) }
+	}
+}
 
-	for x := 0; x < p.ImageWidth; x++{
-		for y := g.Slice.From; y < g.Slice.To; y++ {
-			if isAlive(x, y, world) {
-				c := util.Cell{x, y}
-				cells = append(cells, c)
+//marks the tiles next to halo rows that aren't the same as last turn's, must be called with g.Mut held
+//Next still holds last turn's halos, the turn before it was swapped out
+func (g *Gol) trackHalos(top util.Packed, bottom util.Packed) {
+	height := g.Slice.To - g.Slice.From
+	for tx := 0; tx < g.tilesAcross(); tx++ {
+		for i := 0; i < g.Slice.Halo; i++ {
+			if tileDiffers(top, i, g.Next, i, tx) {
+				g.markAround(tx*tileSize, -g.Slice.Halo, (tx+1)*tileSize, 0)
+			}
+			if tileDiffers(bottom, i, g.Next, height+g.Slice.Halo+i, tx) {
+				g.markAround(tx*tileSize, height, (tx+1)*tileSize, height+g.Slice.Halo)
 			}
 		}
 	}
+}
+
+//cells in the tiles that need working out this turn
+func (g *Gol) activeCells() int {
+	across := g.tilesAcross()
+	height := g.Slice.To - g.Slice.From
+	count := 0
+	for t, active := range g.Active {
+		if !active { continue }
+		w, h := tileSize, tileSize
+		if x := g.Params.ImageWidth - t%across*tileSize; x < w { w = x }
+		if y := height - t/across*tileSize; y < h { h = y }
+		count += w * h
+	}
+	return count
+}
 
-	return cells
+func sameEdges(a stubs.Edges, b stubs.Edges) bool {
+	if len(a.Left) != len(b.Left) { return false }
+	for i := range a.Left {
+		if !bytes.Equal(a.Left[i], b.Left[i]) || !bytes.Equal(a.Right[i], b.Right[i]) { return false }
+	}
+	return true
+}
+
+//the strip without its halos, must be called with g.Mut held
+func (g *Gol) ownRows() util.Packed {
+	return g.Strip.Window(g.Slice.Halo, g.Strip.Height-g.Slice.Halo)
 }
 
 func (g *Gol) aliveStrip() []util.Cell {
-	var cells []util.Cell
-	
+	return g.ownRows().Cells(g.Params.Rule.AliveFrom(), g.Slice.From)
+}
+
+//compares the strip before and after a turn, giving the cells that changed and the grey levels they changed to
+//must be called with g.Mut held
+func (g *Gol) flippedCells(before util.Packed) (cells []util.Cell, levels []uint8) {
+
 	height := g.Slice.To - g.Slice.From
-	for x := 0; x < g.Params.ImageWidth; x++ {
-		for y := 0; y < height; y++ {
-			if isAlive(x, y, g.Strip) {
-				c := util.Cell{x, y+g.Slice.From}
-				cells = append(cells, c)
+	for y := g.Slice.Halo; y < height+g.Slice.Halo; y++ {
+		if !g.Strip.Grey {
+			//only words that differ have flipped cells, found a set bit at a time
+			was, now := before.Row(y), g.Strip.Row(y)
+			for i := range now {
+				for changed := was[i] ^ now[i]; changed != 0; changed &= changed - 1 {
+					x := i*64 + bits.TrailingZeros64(changed)
+					cells = append(cells, util.Cell{X: x, Y: y-g.Slice.Halo+g.Slice.From})
+					levels = append(levels, g.Strip.Get(x, y))
+				}
+			}
+			continue
+		}
+		for x := 0; x < g.Params.ImageWidth; x++ {
+			if before.Get(x, y) != g.Strip.Get(x, y) {
+				cells = append(cells, util.Cell{X: x, Y: y-g.Slice.Halo+g.Slice.From})
+				levels = append(levels, g.Strip.Get(x, y))
 			}
 		}
 	}
 
-	return cells
+	return
+}
+
+func (g *Gol) aliveCount() int {
+	return g.ownRows().Count(g.Params.Rule.AliveFrom())
 }
 
 func resetGol(g *Gol){
 
 	g.setParams(stubs.Params{})
-	g.setWorld(make([][]uint8, 0))
+	g.setStrip(util.Packed{})
+	g.setEdges(stubs.Edges{})
 	g.setTurn(0)
 	g.setDone(make(chan bool, 1))
+	g.setNeighbours("", "")
+	g.resetHalos()
+	g.setJob(0)
 }
 
 type Gol struct {
 	Mut sync.Mutex
-	WorldMut sync.Mutex
+	HaloMut sync.Mutex
 	TurnMut sync.Mutex
 
 	Params stubs.Params
 	Slice stubs.Slice
 	ID int
+	JobID int //the broker job we are set up for, guarded by TurnMut so halos and pings don't wait on a turn
+	Threads int //goroutines the strip is split between each turn
+
+	Strip util.Packed //the slice with Slice.Halo halo rows either side, a bit a cell unless the rule has dying states
+	Edges stubs.Edges //the whole world's first and last columns, only kept when the topology twists the sides
+	Next util.Packed //buffer the next turn is written into, swapped with Strip after each turn
+	Active []bool //tiles of our own rows that need working out next turn, tilesAcross to a row of tiles
 
-	World [][]uint8
-	Strip [][]uint8
+	Above *rpc.Client //neighbours we send our boundary rows to
+	Below *rpc.Client
+
+	//halos received from the neighbours keyed by turn, a fast neighbour can send the next turn's before we've used this one's
+	TopHalos map[int]util.Packed
+	BottomHalos map[int]util.Packed
 
 	Turn int
+	Pinged time.Time //when the broker's heartbeat last reached us, guarded by TurnMut
 	Done chan bool
 }
 
@@ -148,13 +491,25 @@ func (g *Gol) setParams(p stubs.Params){
 	g.Params = p
 }
 
-func (g *Gol) setWorld(w [][]uint8){
+func (g *Gol) setStrip(s util.Packed){
+	g.Mut.Lock(); defer g.Mut.Unlock()
+	g.Strip = s
+	g.Next = util.NewPacked(s.Width, s.Height, s.Grey)
+	//everything needs working out until we know what changes
+	g.Active = make([]bool, g.tilesAcross()*g.tilesDown())
+	for t := range g.Active {
+		g.Active[t] = true
+	}
+}
+
+func (g *Gol) setEdges(e stubs.Edges){
 	g.Mut.Lock(); defer g.Mut.Unlock()
-	g.World = w
+	g.Edges = e
 }
 
 func (g *Gol) initTurn(t int){
 	g.Mut.Lock(); defer g.Mut.Unlock()
+	g.TurnMut.Lock(); defer g.TurnMut.Unlock()
 	g.Turn = t
 }
 
@@ -173,33 +528,102 @@ func (g *Gol) setID(id int){
 	g.ID = id
 }
 
+func (g *Gol) setJob(jobID int) {
+	g.TurnMut.Lock(); defer g.TurnMut.Unlock()
+	g.JobID = jobID
+}
+
+//turns away calls meant for a job we are no longer set up for, a worker only ever works on one job at a time
+func (g *Gol) checkJob(jobID int) error {
+	g.TurnMut.Lock(); defer g.TurnMut.Unlock()
+	if jobID != g.JobID {
+		return fmt.Errorf("worker is set up for job %d, not job %d", g.JobID, jobID)
+	}
+	return nil
+}
+
+func (g *Gol) setThreads(threads int) {
+	g.Mut.Lock(); defer g.Mut.Unlock()
+	if threads <= 0 { threads = defaultThreads }
+	g.Threads = threads
+}
+
 func (g *Gol) setTurn(turn int) {
 	g.Mut.Lock(); defer g.Mut.Unlock()
+	g.TurnMut.Lock(); defer g.TurnMut.Unlock()
 	g.Turn = turn
 }
 
-func (g *Gol) setStrip() (err error){ //depends entirely on slice, this means it can return errors
+//drops any old neighbour connections and dials the new ones
+func (g *Gol) setNeighbours(above string, below string) (err error){
 	g.Mut.Lock(); defer g.Mut.Unlock()
-	if g.Slice.To == 0 && g.Slice.From == 0 {
-		fmt.Println("Slice is nil")
-	}
-	if g.Params.ImageWidth == 0 {
-		fmt.Println("Params is nil")
+	if g.Above != nil { g.Above.Close() }
+	if g.Below != nil { g.Below.Close() }
+	g.Above = nil
+	g.Below = nil
+
+	if above == "" || below == "" {
+		return
 	}
 
-	subStrip := make([][]uint8, g.Slice.To - g.Slice.From)
+	g.Above, err = rpc.Dial("tcp", above)
+	if err != nil { return }
+	g.Below, err = rpc.Dial("tcp", below)
+	return
+}
+
+func (g *Gol) resetHalos() {
+	g.HaloMut.Lock(); defer g.HaloMut.Unlock()
+	g.TopHalos = make(map[int]util.Packed)
+	g.BottomHalos = make(map[int]util.Packed)
+}
 
-	g.WorldMut.Lock()
+func (g *Gol) setHalo(rows util.Packed, turn int, top bool) {
+	g.HaloMut.Lock(); defer g.HaloMut.Unlock()
+	if top {
+		g.TopHalos[turn] = rows
+	} else {
+		g.BottomHalos[turn] = rows
+	}
+}
 
-	j := g.Slice.From
-	for i := range subStrip {
-		subStrip[i] = g.World[j]
-		j++
+//copies the halos received for this turn into the strip, must be called with g.Mut held
+func (g *Gol) applyHalos() (err error){
+	g.HaloMut.Lock(); defer g.HaloMut.Unlock()
+	top, topOk := g.TopHalos[g.Turn]
+	bottom, bottomOk := g.BottomHalos[g.Turn]
+	if !topOk || !bottomOk {
+		return fmt.Errorf("worker %d missing halos for turn %d", g.ID, g.Turn)
+	}
+	if top.Height != g.Slice.Halo || bottom.Height != g.Slice.Halo {
+		return fmt.Errorf("worker %d got halos of %d and %d rows for turn %d, expected %d", g.ID, top.Height, bottom.Height, g.Turn, g.Slice.Halo)
+	}
+	if top.Grey != g.Strip.Grey || bottom.Grey != g.Strip.Grey || top.Width != g.Strip.Width || bottom.Width != g.Strip.Width {
+		return fmt.Errorf("worker %d got halos for turn %d packed differently to its strip", g.ID, g.Turn)
 	}
 
-	g.Strip = subStrip
+	g.trackHalos(top, bottom)
+	g.Strip.SetRows(0, top)
+	g.Strip.SetRows(g.Strip.Height-g.Slice.Halo, bottom)
+	delete(g.TopHalos, g.Turn)
+	delete(g.BottomHalos, g.Turn)
+	return
+}
 
-	g.WorldMut.Unlock()
+//sends our first rows to the worker above and our last rows to the worker below
+func (g *Gol) sendHalos(top util.Packed, bottom util.Packed, turn int, jobID int) (err error){
+	aboveDone := g.Above.Go(stubs.HaloHandler, stubs.HaloRequest{JobID: jobID, Rows: top, Turn: turn, Top: false}, new(stubs.EmptyResponse), nil)
+	belowDone := g.Below.Go(stubs.HaloHandler, stubs.HaloRequest{JobID: jobID, Rows: bottom, Turn: turn, Top: true}, new(stubs.EmptyResponse), nil)
+
+	timeout := time.After(haloTimeout)
+	for _, call := range []*rpc.Call{aboveDone, belowDone} {
+		select {
+		case <-call.Done:
+			if call.Error != nil { return call.Error }
+		case <-timeout:
+			return fmt.Errorf("worker %d timed out sending halos for turn %d", g.ID, turn)
+		}
+	}
 	return
 }
 
@@ -209,13 +633,20 @@ func (g *Gol) Setup(req stubs.SetupRequest, res *stubs.SetupResponse) (err error
 	fmt.Println("Setting up")
 
 	resetGol(g)
+	g.setJob(req.JobID)
 	g.setID(req.ID)
 
 	g.setSlice(req.Slice)
 	g.setParams(req.Params)
-	g.setWorld(req.World)
+	g.setThreads(req.Threads)
+	g.setStrip(req.Strip)
+	g.setEdges(req.Edges)
 	g.initTurn(req.Turn)
-	err = g.setStrip()
+	g.resetHalos()
+	g.setHalo(req.Strip.Rows(0, g.Slice.Halo), req.Turn, true)
+	g.setHalo(req.Strip.Rows(req.Strip.Height-g.Slice.Halo, req.Strip.Height), req.Turn, false)
+	err = g.setNeighbours(req.Above, req.Below)
+	res.ID = req.ID
 	res.Slice = req.Slice
 
 	return err
@@ -225,50 +656,137 @@ func (g *Gol) Setup(req stubs.SetupRequest, res *stubs.SetupResponse) (err error
 func (g *Gol) TakeTurn(req stubs.Request, res *stubs.Response) (err error){
 	runningCalls.Add(1); defer runningCalls.Done()
 
-	g.setWorld(req.World)
-	calculateNextState(g, g.Params, /*_,*/ g.World, g.Slice.From, g.Slice.To, g.Turn)
+	if err = g.checkJob(req.JobID); err != nil { return }
+
+	top, bottom, turn, err := g.step(req, res)
+	if err != nil { return err }
+
+	return g.sendHalos(top, bottom, turn, req.JobID)
+}
+
+//steps the strip on a turn and fills in the response, giving back the rows the neighbours need as halos and the turn they're for
+func (g *Gol) step(req stubs.Request, res *stubs.Response) (top util.Packed, bottom util.Packed, turn int, err error) {
+	g.Mut.Lock(); defer g.Mut.Unlock()
+	if req.Turn != g.Turn {
+		return top, bottom, 0, fmt.Errorf("worker %d asked to step from turn %d but is on turn %d", g.ID, req.Turn, g.Turn)
+	}
+
+	if err = g.applyHalos(); err != nil {
+		return top, bottom, 0, err
+	}
+	//the cells past a twisted side are in other workers' rows, so any change there wakes up our sides
+	if g.Params.Topology.TwistsSides() && !sameEdges(g.Edges, req.Edges) {
+		height := g.Slice.To - g.Slice.From
+		g.markAround(0, 0, 0, height)
+		g.markAround(g.Params.ImageWidth, 0, g.Params.ImageWidth, height)
+	}
+	g.Edges = req.Edges
+	active := g.activeCells()
+
+	g.stepStrip()
+	g.trackActive()
+	g.Strip, g.Next = g.Next, g.Strip
+
+	//copy the boundary rows out, the neighbours keep them until their next turn
+	//rows going over the top or bottom of the world arrive as they're seen from the other side
+	height := g.Slice.To - g.Slice.From
+	top = g.Strip.Rows(g.Slice.Halo, 2*g.Slice.Halo)
+	bottom = g.Strip.Rows(height, height+g.Slice.Halo)
+	if g.Slice.From == 0 { top = g.Params.Topology.OverEndRows(top) }
+	if g.Slice.To == g.Params.ImageHeight { bottom = g.Params.Topology.OverEndRows(bottom) }
 
 	g.TurnMut.Lock() //we lock on read to avoid stale values and race conditions
-	g.setTurn(g.Turn + 1)
+	g.Turn++
 	g.TurnMut.Unlock()
 
-	g.Mut.Lock()
 	res.ID = g.ID
-	res.Strip = g.Strip
 	res.Slice = g.Slice
 	res.Turn = g.Turn
-	res.Alive = g.aliveStrip()
+	res.AliveCount = g.aliveCount()
+	res.Active = active
+	if g.Params.Topology.Grows() {
+		res.Bounds = g.ownRows().Bounds().Add(util.Cell{Y: g.Slice.From})
+	}
+	if g.Params.MaxPeriod > 0 {
+		res.Hash = g.ownRows().Hash(g.Slice.From)
+	}
+	if req.Flips {
+		res.Flipped, res.Levels = g.flippedCells(g.Next) //Next holds the turn we just stepped from
+	}
+	if g.Params.Topology.TwistsSides() {
+		res.Edges = g.edgeColumns()
+	}
+	return top, bottom, g.Turn, nil
+}
 
-	g.Mut.Unlock()
+//receives a boundary row from a neighbouring worker, to be used as a halo on the next turn
+func (g *Gol) ReceiveHalo(req stubs.HaloRequest, res *stubs.EmptyResponse) (err error){
+	runningCalls.Add(1); defer runningCalls.Done()
 
+	if err = g.checkJob(req.JobID); err != nil { return }
+	g.setHalo(req.Rows, req.Turn, req.Top)
 	return
 }
 
+//returns the worker's strip without its halos so the broker can rebuild the world
+func (g *Gol) PollWorld(req stubs.JobRequest, res *stubs.Response) (err error){
+	runningCalls.Add(1); defer runningCalls.Done()
+
+	if err = g.checkJob(req.JobID); err != nil { return }
+
+	g.Mut.Lock(); defer g.Mut.Unlock()
+	res.Strip = g.ownRows().Rows(0, g.Slice.To-g.Slice.From)
+	res.ID = g.ID
+	res.Slice = g.Slice
+	res.Turn = g.Turn
 
+	return
+}
 
-func (g *Gol) ReportAlive(req stubs.EmptyRequest, res *stubs.AliveResponse) (err error){
+func (g *Gol) ReportAlive(req stubs.JobRequest, res *stubs.AliveResponse) (err error){
 	runningCalls.Add(1); defer runningCalls.Done()
 
-	g.WorldMut.Lock(); defer g.WorldMut.Unlock()
-	g.TurnMut.Lock(); defer g.TurnMut.Unlock()
-	if g.Params.Turns == 0 {
-		res.Alive = g.calculateAliveCells(g.Params, g.World)
-	} else {
-		res.Alive = g.aliveStrip()
-	}
+	if err = g.checkJob(req.JobID); err != nil { return }
+
+	g.Mut.Lock(); defer g.Mut.Unlock()
+	res.Alive = g.aliveStrip()
+	res.CellsCount = len(res.Alive)
 	res.OnTurn = g.Turn
 
 
 	return
 }
 
+//answers the broker's heartbeat, this only takes TurnMut so it stays quick even while a turn is running
+func (g *Gol) Ping(req stubs.EmptyRequest, res *stubs.PingResponse) (err error){
+	runningCalls.Add(1); defer runningCalls.Done()
+
+	g.TurnMut.Lock(); defer g.TurnMut.Unlock()
+	g.Pinged = time.Now()
+	res.Turn = g.Turn
+	res.JobID = g.JobID
+	return
+}
+
+//how long it has been since the broker's heartbeat last reached us
+func (g *Gol) sincePinged() time.Duration {
+	g.TurnMut.Lock(); defer g.TurnMut.Unlock()
+	return time.Since(g.Pinged)
+}
+
 //asks the only looping rpc call to finish when ready (takeTurns())
-func (g *Gol) Finish(req stubs.EmptyRequest, res *stubs.EmptyResponse) (err error){
+func (g *Gol) Finish(req stubs.JobRequest, res *stubs.EmptyResponse) (err error){
 	runningCalls.Add(1); defer runningCalls.Done()
 
+	if err = g.checkJob(req.JobID); err != nil { return }
+
 	g.Mut.Lock()
-	g.Done <- true
+	select {
+	case g.Done <- true:
+	default:
+	}
 	g.Mut.Unlock()
+	g.setJob(0) //back in the pool, free for the next job
 
 	return
 }
@@ -285,26 +803,99 @@ func (g *Gol) Kill(req stubs.EmptyRequest, res *stubs.EmptyResponse) (err error)
 
 func runServer(s *rpc.Server, l *net.Listener){
 	go s.Accept(*l)
-	<-kill
+
+	//being interrupted is treated the same as the broker killing us, so we still deregister on the way out
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+
+	select {
+	case <-kill:
+	case <-interrupt:
+	}
 	fmt.Println("closed acceptor")
 	return
 }
 
+//keeps us in the broker's pool for as long as we're up: keeps trying to join it, as the broker may well start
+//after we do, then joins it again whenever its heartbeat stops reaching us, as it may have restarted and forgotten us
+//registering again when the broker already has us is harmless, so it doesn't matter if it was only slow
+func register(g *Gol, broker string, ip string, stop <-chan bool) {
+	registered := false
+	for {
+		if !registered || g.sincePinged() > reregisterAfter {
+			err := registerWith(broker, ip)
+			if err == nil {
+				if !registered { fmt.Println("registered with broker", broker, "as", ip) }
+				registered = true
+				g.TurnMut.Lock()
+				g.Pinged = time.Now() //gives the broker time to start pinging us before we try again
+				g.TurnMut.Unlock()
+			} else {
+				fmt.Printf("Error registering with broker %s; %s\n", broker, err)
+			}
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-time.After(registerRetry):
+		}
+	}
+}
+
+func registerWith(broker string, ip string) error {
+	client, err := rpc.Dial("tcp", broker)
+	if err != nil { return err }
+	defer client.Close()
+	return client.Call(stubs.RegisterHandler, stubs.WorkerRequest{Ip: ip}, new(stubs.EmptyResponse))
+}
+
+func deregister(broker string, ip string) {
+	client, err := rpc.Dial("tcp", broker)
+	if err != nil {
+		fmt.Printf("Error deregistering from broker %s; %s\n", broker, err)
+		return
+	}
+	defer client.Close()
+
+	err = client.Call(stubs.DeregisterHandler, stubs.WorkerRequest{Ip: ip}, new(stubs.EmptyResponse))
+	if err != nil {
+		fmt.Printf("Error deregistering from broker %s; %s\n", broker, err)
+	}
+}
+
 func main() {
-	portPtr := flag.String("port", "8030", "port used; default: 8030")
+	portPtr := flag.String("port", "8030", "port used, 0 picks any free port; default: 8030")
+	threadsPtr := flag.Int("threads", runtime.NumCPU(), "goroutines to split each strip between, unless the broker asks for a number; default: number of CPUs")
+	brokerPtr := flag.String("broker", "", "broker address to register with, leave empty to wait to be dialled through -worker_ips")
+	ipPtr := flag.String("ip", "localhost", "host the broker and other workers should use to reach us")
 	flag.Parse()
 
+	defaultThreads = *threadsPtr
+
 	server := rpc.NewServer()
-	err := server.Register(&Gol{})
+	g := &Gol{}
+	err := server.Register(g)
 	if err != nil {
 		fmt.Printf("Error registering new rpc server with Gol struct; %s\n", err)
 	}
 	listener, err := net.Listen("tcp", ":"+*portPtr)
 	if(err != nil) { panic(err) }
-	fmt.Println("server listening on port "+*portPtr)
+	port := strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+	fmt.Println("server listening on port "+port)
+
+	stopRegistering := make(chan bool, 1)
+	if *brokerPtr != "" {
+		go register(g, *brokerPtr, *ipPtr+":"+port, stopRegistering)
+	}
 
 	runServer(server, &listener)
 
+	if *brokerPtr != "" {
+		stopRegistering <- true
+		deregister(*brokerPtr, *ipPtr+":"+port)
+	}
+
 	fmt.Println("server waiting for all calls to terminate")
 	runningCalls.Wait()
 	fmt.Println("all calls terminated")
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/gol/stubs"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// naive steps the whole world a turn, counting every cell's neighbours where the topology puts them.
+func naive(world [][]uint8, rule util.Rule, topology util.Topology) [][]uint8 {
+	height, width := len(world), len(world[0])
+	next := genWorldBlock(height, width)
+	for y := range next {
+		for x := range next[y] {
+			neighbours := 0
+			for _, offset := range rule.Offsets() {
+				if wx, wy, ok := topology.Wrap(x+offset.X, y+offset.Y, width, height); ok && rule.Alive(world[wy][wx]) {
+					neighbours++
+				}
+			}
+			next[y][x] = rule.Step(world[y][x], neighbours)
+		}
+	}
+	return next
+}
+
+// cluster plays the broker and the halo exchange for workers that each hold a strip of the same world.
+type cluster struct {
+	workers []*Gol
+	edges   stubs.Edges
+	tracked bool // whether the workers skip the tiles they tracked as quiet, or work everything out every turn
+	active  int  // cells worked out over every turn so far
+}
+
+func newCluster(t *testing.T, world [][]uint8, p stubs.Params, strips int, tracked bool) *cluster {
+	height, width := len(world), len(world[0])
+	halo := p.Rule.Reach()
+	c := &cluster{tracked: tracked}
+	if p.Topology.TwistsSides() {
+		c.edges = worldEdges(world, halo)
+	}
+	for i := 0; i < strips; i++ {
+		from, to := i*height/strips, (i+1)*height/strips
+		rows := make([][]uint8, 0, to-from+2*halo)
+		for y := from - halo; y < to+halo; y++ {
+			if y < 0 || y >= height {
+				rows = append(rows, p.Topology.OverEnd(world[(y+height)%height]))
+			} else {
+				rows = append(rows, append([]uint8(nil), world[y]...))
+			}
+		}
+
+		g := &Gol{}
+		req := stubs.SetupRequest{ID: i, Slice: stubs.Slice{From: from, To: to, Halo: halo}, Params: p, Threads: 2, Edges: c.edges,
+			Strip: util.Pack(rows, p.Rule.Generations())}
+		if err := g.Setup(req, new(stubs.SetupResponse)); err != nil {
+			t.Fatal(err)
+		}
+		c.workers = append(c.workers, g)
+	}
+	if width != p.ImageWidth || height != p.ImageHeight {
+		t.Fatalf("the world is %dx%d but the params say %dx%d", width, height, p.ImageWidth, p.ImageHeight)
+	}
+	return c
+}
+
+// worldEdges is the world's first and last few columns, as the broker sends them for a twisted topology.
+func worldEdges(world [][]uint8, columns int) stubs.Edges {
+	edges := stubs.Edges{Left: make([][]uint8, columns), Right: make([][]uint8, columns)}
+	for i := range edges.Left {
+		edges.Left[i] = make([]uint8, len(world))
+		edges.Right[i] = make([]uint8, len(world))
+		for y, row := range world {
+			edges.Left[i][y] = row[i]
+			edges.Right[i][y] = row[len(row)-1-i]
+		}
+	}
+	return edges
+}
+
+// step takes a turn on every worker, then hands each one's boundary rows to the workers above and below.
+func (c *cluster) step(t *testing.T) {
+	n := len(c.workers)
+	tops, bottoms := make([]util.Packed, n), make([]util.Packed, n)
+	var edges stubs.Edges
+	turn := 0
+	for i, g := range c.workers {
+		if !c.tracked {
+			for tile := range g.Active {
+				g.Active[tile] = true
+			}
+		}
+		res := new(stubs.Response)
+		var err error
+		tops[i], bottoms[i], turn, err = g.step(stubs.Request{Turn: g.Turn, Edges: c.edges}, res)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.active += res.Active
+
+		if len(res.Edges.Left) > 0 {
+			if edges.Left == nil {
+				edges = stubs.Edges{Left: make([][]uint8, len(res.Edges.Left)), Right: make([][]uint8, len(res.Edges.Left))}
+				for k := range edges.Left {
+					edges.Left[k] = make([]uint8, g.Params.ImageHeight)
+					edges.Right[k] = make([]uint8, g.Params.ImageHeight)
+				}
+			}
+			for k := range edges.Left {
+				copy(edges.Left[k][g.Slice.From:], res.Edges.Left[k])
+				copy(edges.Right[k][g.Slice.From:], res.Edges.Right[k])
+			}
+		}
+	}
+	c.edges = edges
+
+	// the worker below gets our last rows as its top halo, the one above our first rows as its bottom halo
+	for i := range c.workers {
+		c.workers[(i+1)%n].setHalo(bottoms[i], turn, true)
+		c.workers[(i+n-1)%n].setHalo(tops[i], turn, false)
+	}
+}
+
+// world puts the workers' rows back together.
+func (c *cluster) world() [][]uint8 {
+	var world [][]uint8
+	for _, g := range c.workers {
+		world = append(world, g.ownRows().Unpack()...)
+	}
+	return world
+}
+
+// glider puts a glider with its top left at x, y, heading right if dx is 1 or left if it's -1, and down or up the same with dy.
+func glider(world [][]uint8, x, y, dx, dy int) {
+	for _, cell := range []util.Cell{{X: 1, Y: 0}, {X: 2, Y: 1}, {X: 0, Y: 2}, {X: 1, Y: 2}, {X: 2, Y: 2}} {
+		if dx < 0 {
+			cell.X = 2 - cell.X
+		}
+		if dy < 0 {
+			cell.Y = 2 - cell.Y
+		}
+		world[y+cell.Y][x+cell.X] = 255
+	}
+}
+
+// soup fills a size by size square with its top left at x, y with random alive cells.
+func soup(world [][]uint8, random *rand.Rand, x, y, size int) {
+	for sy := y; sy < y+size; sy++ {
+		for sx := x; sx < x+size; sx++ {
+			if random.Intn(2) == 0 {
+				world[sy][sx] = 255
+			}
+		}
+	}
+}
+
+// testWorld is mostly empty, so most tiles go quiet, with things happening across tile boundaries, strip
+// boundaries and the edges and corners of the world, which are where tracking can go wrong.
+func testWorld(width, height int) [][]uint8 {
+	random := rand.New(rand.NewSource(1))
+	world := genWorldBlock(height, width)
+	// across a tile boundary both ways, inside the first strip
+	glider(world, 58, 10, 1, 1)
+	// into the strip below, and the one below that when there are three
+	glider(world, 20, height/3-6, -1, 1)
+	glider(world, 100, 2*height/3-6, 1, 1)
+	// over the top, bottom and sides, and each corner
+	glider(world, 90, 2, 1, -1)
+	glider(world, 30, height-6, -1, 1)
+	glider(world, 1, 1, -1, -1)
+	glider(world, width-4, 1, 1, -1)
+	glider(world, 1, height-4, -1, 1)
+	glider(world, width-4, height-4, 1, 1)
+	soup(world, random, width-8, height/2, 8)
+	soup(world, random, 0, height/3-4, 8)
+	return world
+}
+
+// TestTrackingMatchesFullRecompute steps strips with quiet tiles skipped against working out every cell every turn.
+func TestTrackingMatchesFullRecompute(t *testing.T) {
+	const width, height, turns = 260, 200, 50
+	rules := []util.Rule{util.Conway, util.MustParseRule("/2/3"), util.MustParseRule("R2,C0,M1,S5..9,B7..9,NM")}
+	topologies := []util.Topology{util.Torus, util.Plane, util.HorizontalCylinder, util.VerticalCylinder, util.KleinBottle, util.CrossSurface}
+	trackedActive, fullActive := 0, 0
+	for _, rule := range rules {
+		for _, topology := range topologies {
+			for _, strips := range []int{1, 3} {
+				t.Run(fmt.Sprintf("%v/%v/%d", rule, topology, strips), func(t *testing.T) {
+					p := stubs.Params{ImageWidth: width, ImageHeight: height, Rule: rule, Topology: topology}
+					world := testWorld(width, height)
+					tracked := newCluster(t, world, p, strips, true)
+					full := newCluster(t, world, p, strips, false)
+					for turn := 1; turn <= turns; turn++ {
+						tracked.step(t)
+						full.step(t)
+						world = naive(world, rule, topology)
+						got, expected := tracked.world(), full.world()
+						for y := range expected {
+							for x := range expected[y] {
+								if got[y][x] != expected[y][x] {
+									t.Fatalf("turn %d: cell %d,%d is %d tracked but %d worked out in full", turn, x, y, got[y][x], expected[y][x])
+								}
+								if expected[y][x] != world[y][x] {
+									t.Fatalf("turn %d: cell %d,%d is %d worked out in full but %d stepping the whole world", turn, x, y, expected[y][x], world[y][x])
+								}
+							}
+						}
+					}
+					trackedActive += tracked.active
+					fullActive += full.active
+				})
+			}
+		}
+	}
+	// otherwise the test says nothing about tracking, though soups near the edges can keep a few of the runs busy all over
+	if trackedActive >= fullActive {
+		t.Errorf("tracking worked out %d cells, no fewer than the %d of working out everything", trackedActive, fullActive)
+	}
+}
@@ -0,0 +1,43 @@
+package util
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Engine is what works out each turn of a job.
+// The zero Engine splits the world into strips of rows between the broker's workers.
+type Engine int
+
+const (
+	Strips   Engine = iota // strips of rows stepped by the workers, swapping halos each turn
+	HashLife               // Gosper's HashLife on the broker itself, jumping over turns it has seen before
+)
+
+var engineNames = map[Engine]string{
+	Strips:   "strips",
+	HashLife: "hashlife",
+}
+
+// ParseEngine reads an engine by its name: strips or hashlife.
+func ParseEngine(s string) (Engine, error) {
+	for engine, name := range engineNames {
+		if strings.EqualFold(strings.TrimSpace(s), name) {
+			return engine, nil
+		}
+	}
+	return Strips, fmt.Errorf("unknown engine %q, expected one of strips or hashlife", s)
+}
+
+func (e Engine) String() string {
+	if name, ok := engineNames[e]; ok {
+		return name
+	}
+	return fmt.Sprintf("Engine(%d)", int(e))
+}
+
+// Set parses an engine name into e, so it can be given as a flag.
+func (e *Engine) Set(s string) (err error) {
+	*e, err = ParseEngine(s)
+	return
+}
@@ -0,0 +1,71 @@
+package util
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Neighbourhood is the shape of the cells around a cell that count as its neighbours.
+// The zero Neighbourhood is Moore's, every cell in the square around it.
+type Neighbourhood int
+
+const (
+	Moore      Neighbourhood = iota // the square around the cell
+	VonNeumann                      // the diamond around the cell, no further than the range in steps up, down, left and right
+	Hexagonal                       // a hexagon, emulated on the square grid by leaving out the top right and bottom left corners
+)
+
+var neighbourhoodNames = map[Neighbourhood]string{
+	Moore:      "moore",
+	VonNeumann: "vonneumann",
+	Hexagonal:  "hex",
+}
+
+// ParseNeighbourhood reads a neighbourhood by its name: moore, vonneumann or hex.
+func ParseNeighbourhood(s string) (Neighbourhood, error) {
+	for neighbourhood, name := range neighbourhoodNames {
+		if strings.EqualFold(strings.TrimSpace(s), name) {
+			return neighbourhood, nil
+		}
+	}
+	return Moore, fmt.Errorf("unknown neighbourhood %q, expected one of moore, vonneumann or hex", s)
+}
+
+func (n Neighbourhood) String() string {
+	if name, ok := neighbourhoodNames[n]; ok {
+		return name
+	}
+	return fmt.Sprintf("Neighbourhood(%d)", int(n))
+}
+
+// Contains says whether the cell dx, dy away is within reach of the centre.
+func (n Neighbourhood) Contains(dx, dy, reach int) bool {
+	abs := func(i int) int {
+		if i < 0 {
+			return -i
+		}
+		return i
+	}
+	switch n {
+	case VonNeumann:
+		return abs(dx)+abs(dy) <= reach
+	case Hexagonal:
+		// y goes down the world, so up and to the right is dx > 0, dy < 0, which is two steps on the hexagon
+		return abs(dx) <= reach && abs(dy) <= reach && abs(dx-dy) <= reach
+	default:
+		return abs(dx) <= reach && abs(dy) <= reach
+	}
+}
+
+// Offsets lists where each neighbour is relative to the centre, which isn't one of them.
+func (n Neighbourhood) Offsets(reach int) []Cell {
+	var offsets []Cell
+	for dy := -reach; dy <= reach; dy++ {
+		for dx := -reach; dx <= reach; dx++ {
+			if (dx != 0 || dy != 0) && n.Contains(dx, dy, reach) {
+				offsets = append(offsets, Cell{X: dx, Y: dy})
+			}
+		}
+	}
+	return offsets
+}
@@ -0,0 +1,83 @@
+package util
+
+import (
+	"testing"
+)
+
+func TestOffsets(t *testing.T) {
+	tests := []struct {
+		neighbourhood Neighbourhood
+		reach         int
+		count         int
+	}{
+		{Moore, 1, 8},
+		{VonNeumann, 1, 4},
+		{Hexagonal, 1, 6},
+		{Moore, 2, 24},
+		{VonNeumann, 2, 12},
+		{Hexagonal, 2, 18},
+		{Moore, 5, 120},
+		{VonNeumann, 5, 60},
+		{Hexagonal, 5, 90},
+	}
+	for _, test := range tests {
+		offsets := test.neighbourhood.Offsets(test.reach)
+		if len(offsets) != test.count {
+			t.Errorf("%v range %d: %d offsets, expected %d", test.neighbourhood, test.reach, len(offsets), test.count)
+		}
+
+		seen := map[Cell]bool{}
+		for _, offset := range offsets {
+			if offset == (Cell{}) {
+				t.Errorf("%v range %d: the cell itself is one of its neighbours", test.neighbourhood, test.reach)
+			}
+			if offset.X < -test.reach || offset.X > test.reach || offset.Y < -test.reach || offset.Y > test.reach {
+				t.Errorf("%v range %d: %v is out of reach", test.neighbourhood, test.reach, offset)
+			}
+			if seen[offset] {
+				t.Errorf("%v range %d: %v is there twice", test.neighbourhood, test.reach, offset)
+			}
+			seen[offset] = true
+		}
+		// a cell is its neighbour's neighbour
+		for offset := range seen {
+			if !seen[Cell{X: -offset.X, Y: -offset.Y}] {
+				t.Errorf("%v range %d: has %v but not the other way", test.neighbourhood, test.reach, offset)
+			}
+		}
+	}
+}
+
+func TestContains(t *testing.T) {
+	tests := []struct {
+		neighbourhood Neighbourhood
+		dx, dy        int
+		contains      bool
+	}{
+		{Moore, 1, 1, true},
+		{Moore, 2, 0, false},
+		{VonNeumann, 1, 0, true},
+		{VonNeumann, 1, 1, false},
+		// the hexagon leaves out the top right and bottom left corners
+		{Hexagonal, 1, 1, true},
+		{Hexagonal, -1, -1, true},
+		{Hexagonal, 1, -1, false},
+		{Hexagonal, -1, 1, false},
+	}
+	for _, test := range tests {
+		if got := test.neighbourhood.Contains(test.dx, test.dy, 1); got != test.contains {
+			t.Errorf("%v: %d,%d contained is %v, expected %v", test.neighbourhood, test.dx, test.dy, got, test.contains)
+		}
+	}
+}
+
+func TestParseNeighbourhood(t *testing.T) {
+	for _, neighbourhood := range []Neighbourhood{Moore, VonNeumann, Hexagonal} {
+		if parsed, err := ParseNeighbourhood(" " + neighbourhood.String() + " "); err != nil || parsed != neighbourhood {
+			t.Errorf("%v: parsed as %v, %v", neighbourhood, parsed, err)
+		}
+	}
+	if _, err := ParseNeighbourhood("triangle"); err == nil {
+		t.Errorf("expected an error for triangle")
+	}
+}
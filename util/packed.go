@@ -0,0 +1,238 @@
+package util
+
+import "math/bits"
+
+// Packed is a world, or a few rows of one, packed for sending between machines and for stepping.
+// When cells are only ever dead or alive each cell takes one bit, 64 cells to a word, and the bits
+// past the end of a row are always 0. When they have grey levels in between, each cell takes a byte.
+type Packed struct {
+	Width, Height int
+	Grey          bool     // a byte a cell in Levels rather than a bit a cell in Bits
+	Bits          []uint64 // Stride words a row, cell x is bit x%64 of the row's word x/64
+	Levels        []uint8  // Width bytes a row
+}
+
+// NewPacked makes a world of dead cells.
+func NewPacked(width, height int, grey bool) Packed {
+	p := Packed{Width: width, Height: height, Grey: grey}
+	if grey {
+		p.Levels = make([]uint8, width*height)
+	} else {
+		p.Bits = make([]uint64, p.Stride()*height)
+	}
+	return p
+}
+
+// Pack packs rows of grey levels. Without grey levels anything other than 0 is packed as alive.
+func Pack(rows [][]uint8, grey bool) Packed {
+	width := 0
+	if len(rows) > 0 {
+		width = len(rows[0])
+	}
+	p := NewPacked(width, len(rows), grey)
+	for y, row := range rows {
+		if grey {
+			copy(p.Levels[y*width:], row)
+			continue
+		}
+		words := p.Row(y)
+		for x, level := range row {
+			if level != 0 {
+				words[x/64] |= 1 << uint(x%64)
+			}
+		}
+	}
+	return p
+}
+
+// Unpack gives the rows back as grey levels, alive cells packed as bits come back as 255.
+func (p Packed) Unpack() [][]uint8 {
+	rows := make([][]uint8, p.Height)
+	for y := range rows {
+		rows[y] = make([]uint8, p.Width)
+		if p.Grey {
+			copy(rows[y], p.Levels[y*p.Width:(y+1)*p.Width])
+			continue
+		}
+		for x := range rows[y] {
+			rows[y][x] = p.Get(x, y)
+		}
+	}
+	return rows
+}
+
+// Stride is how many words each row takes when packed a bit a cell.
+func (p Packed) Stride() int {
+	return (p.Width + 63) / 64
+}
+
+// Row is the words of row y, sharing the packed world's memory. Only for worlds packed a bit a cell.
+func (p Packed) Row(y int) []uint64 {
+	stride := p.Stride()
+	return p.Bits[y*stride : (y+1)*stride]
+}
+
+// LevelRows gives every row as grey levels, sharing the packed world's memory. Only for grey worlds.
+func (p Packed) LevelRows() [][]uint8 {
+	rows := make([][]uint8, p.Height)
+	for y := range rows {
+		rows[y] = p.Levels[y*p.Width : (y+1)*p.Width]
+	}
+	return rows
+}
+
+// Get is the grey level of the cell at x, y.
+func (p Packed) Get(x, y int) uint8 {
+	if p.Grey {
+		return p.Levels[y*p.Width+x]
+	}
+	if p.Bits[y*p.Stride()+x/64]&(1<<uint(x%64)) != 0 {
+		return 255
+	}
+	return 0
+}
+
+// Set changes the cell at x, y to a grey level, anything other than 0 is alive without grey levels.
+func (p Packed) Set(x, y int, level uint8) {
+	if p.Grey {
+		p.Levels[y*p.Width+x] = level
+		return
+	}
+	word := &p.Bits[y*p.Stride()+x/64]
+	if level != 0 {
+		*word |= 1 << uint(x%64)
+	} else {
+		*word &^= 1 << uint(x%64)
+	}
+}
+
+// Window is the rows from up to but not including to, sharing the packed world's memory.
+func (p Packed) Window(from, to int) Packed {
+	window := Packed{Width: p.Width, Height: to - from, Grey: p.Grey}
+	if p.Grey {
+		window.Levels = p.Levels[from*p.Width : to*p.Width]
+	} else {
+		window.Bits = p.Bits[from*p.Stride() : to*p.Stride()]
+	}
+	return window
+}
+
+// Rows copies out rows from up to but not including to.
+func (p Packed) Rows(from, to int) Packed {
+	rows := NewPacked(p.Width, to-from, p.Grey)
+	if p.Grey {
+		copy(rows.Levels, p.Levels[from*p.Width:to*p.Width])
+	} else {
+		copy(rows.Bits, p.Bits[from*p.Stride():to*p.Stride()])
+	}
+	return rows
+}
+
+// SetRows copies rows in over the rows starting at y. They must be packed the same way.
+func (p Packed) SetRows(y int, rows Packed) {
+	if p.Grey {
+		copy(p.Levels[y*p.Width:], rows.Levels)
+	} else {
+		copy(p.Bits[y*p.Stride():], rows.Bits)
+	}
+}
+
+// Count is how many cells are at or above the grey level aliveFrom.
+func (p Packed) Count(aliveFrom uint8) int {
+	count := 0
+	if p.Grey {
+		for _, level := range p.Levels {
+			if level >= aliveFrom {
+				count++
+			}
+		}
+		return count
+	}
+	for _, word := range p.Bits {
+		count += bits.OnesCount64(word)
+	}
+	return count
+}
+
+// Bounds is the smallest rectangle holding every cell that isn't dead.
+func (p Packed) Bounds() Rect {
+	var r Rect
+	for y := 0; y < p.Height; y++ {
+		left, right := p.Width, 0
+		if p.Grey {
+			for x, level := range p.Levels[y*p.Width : (y+1)*p.Width] {
+				if level != 0 {
+					if x < left {
+						left = x
+					}
+					right = x + 1
+				}
+			}
+		} else {
+			for i, word := range p.Row(y) {
+				if word == 0 {
+					continue
+				}
+				if x := i*64 + bits.TrailingZeros64(word); x < left {
+					left = x
+				}
+				right = i*64 + 64 - bits.LeadingZeros64(word)
+			}
+		}
+		if left < right {
+			r = r.Union(Rect{Min: Cell{X: left, Y: y}, Max: Cell{X: right, Y: y + 1}})
+		}
+	}
+	return r
+}
+
+// Crop copies out the cells in r, which must be inside the packed world.
+func (p Packed) Crop(r Rect) Packed {
+	cropped := NewPacked(r.Max.X-r.Min.X, r.Max.Y-r.Min.Y, p.Grey)
+	for y := 0; y < cropped.Height; y++ {
+		for x := 0; x < cropped.Width; x++ {
+			cropped.Set(x, y, p.Get(x+r.Min.X, y+r.Min.Y))
+		}
+	}
+	return cropped
+}
+
+// Cells lists the cells at or above the grey level aliveFrom, with y counted from firstY.
+func (p Packed) Cells(aliveFrom uint8, firstY int) []Cell {
+	var cells []Cell
+	for y := 0; y < p.Height; y++ {
+		for x := 0; x < p.Width; x++ {
+			if p.Get(x, y) >= aliveFrom {
+				cells = append(cells, Cell{X: x, Y: y + firstY})
+			}
+		}
+	}
+	return cells
+}
+
+// Hash is a hash of the cells, with y counted from firstY. It is a sum over the rows, so the hashes
+// of the strips of a world add up to the hash of the whole world however it was split.
+func (p Packed) Hash(firstY int) uint64 {
+	var sum uint64
+	for y := 0; y < p.Height; y++ {
+		h := uint64(14695981039346656037)
+		if p.Grey {
+			for _, level := range p.Levels[y*p.Width : (y+1)*p.Width] {
+				h = (h ^ uint64(level)) * 1099511628211
+			}
+		} else {
+			for _, word := range p.Row(y) {
+				h = (h ^ word) * 1099511628211
+			}
+		}
+		sum += mix(h ^ uint64(y+firstY)*0x9e3779b97f4a7c15)
+	}
+	return sum
+}
+
+// mix spreads every bit of x over all of the bits it returns, splitmix64's finaliser.
+func mix(x uint64) uint64 {
+	x = (x ^ x>>30) * 0xbf58476d1ce4e5b9
+	x = (x ^ x>>27) * 0x94d049bb133111eb
+	return x ^ x>>31
+}
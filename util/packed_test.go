@@ -0,0 +1,137 @@
+package util
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// widths either side of the words cells are packed into
+var packedWidths = []int{1, 5, 63, 64, 65, 100, 128, 130}
+
+// randomRows makes rows of random grey levels, or of just 0 and 255 when they aren't grey.
+func randomRows(random *rand.Rand, width, height int, grey bool) [][]uint8 {
+	rows := make([][]uint8, height)
+	for y := range rows {
+		rows[y] = make([]uint8, width)
+		for x := range rows[y] {
+			switch {
+			case random.Intn(3) > 0:
+			case grey:
+				rows[y][x] = uint8(random.Intn(256))
+			default:
+				rows[y][x] = 255
+			}
+		}
+	}
+	return rows
+}
+
+func TestPackRoundTrip(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	for _, grey := range []bool{false, true} {
+		for _, width := range packedWidths {
+			rows := randomRows(random, width, 7, grey)
+			packed := Pack(rows, grey)
+			if packed.Width != width || packed.Height != 7 {
+				t.Fatalf("width %d grey %v: packed as %dx%d", width, grey, packed.Width, packed.Height)
+			}
+			if got := packed.Unpack(); !reflect.DeepEqual(got, rows) {
+				t.Errorf("width %d grey %v: unpacked\n%v\nexpected\n%v", width, grey, got, rows)
+			}
+			if grey {
+				continue
+			}
+			// the bits past the end of each row stay clear, or they would count as cells
+			for y := 0; y < packed.Height; y++ {
+				row := packed.Row(y)
+				if extra := uint(packed.Stride()*64 - width); extra > 0 && row[len(row)-1]>>(64-extra) != 0 {
+					t.Errorf("width %d: row %d has bits set past its end", width, y)
+				}
+			}
+		}
+	}
+}
+
+func TestPackTakesAnyLevelAsAlive(t *testing.T) {
+	packed := Pack([][]uint8{{0, 1, 128, 255}}, false)
+	if got := packed.Unpack()[0]; !reflect.DeepEqual(got, []uint8{0, 255, 255, 255}) {
+		t.Errorf("got %v, expected [0 255 255 255]", got)
+	}
+}
+
+func TestBoundsAndCrop(t *testing.T) {
+	for _, grey := range []bool{false, true} {
+		for _, width := range packedWidths {
+			empty := NewPacked(width, 4, grey)
+			if bounds := empty.Bounds(); !bounds.Empty() {
+				t.Errorf("width %d grey %v: an empty world has bounds %v", width, grey, bounds)
+			}
+			if cropped := empty.Crop(empty.Bounds()); cropped.Width != 0 || cropped.Height != 0 {
+				t.Errorf("width %d grey %v: cropping an empty world gave %dx%d", width, grey, cropped.Width, cropped.Height)
+			}
+
+			full := NewPacked(width, 4, grey)
+			for y := 0; y < full.Height; y++ {
+				for x := 0; x < width; x++ {
+					full.Set(x, y, 255)
+				}
+			}
+			expected := Rect{Max: Cell{X: width, Y: 4}}
+			if bounds := full.Bounds(); bounds != expected {
+				t.Errorf("width %d grey %v: a full world has bounds %v, expected %v", width, grey, bounds, expected)
+			}
+			if cropped := full.Crop(full.Bounds()); !reflect.DeepEqual(cropped.Unpack(), full.Unpack()) {
+				t.Errorf("width %d grey %v: cropping a full world to its bounds changed it", width, grey)
+			}
+
+			// a single cell at the far end of a row, past the first word on the wider worlds
+			one := NewPacked(width, 4, grey)
+			one.Set(width-1, 2, 255)
+			expected = Rect{Min: Cell{X: width - 1, Y: 2}, Max: Cell{X: width, Y: 3}}
+			if bounds := one.Bounds(); bounds != expected {
+				t.Errorf("width %d grey %v: one cell has bounds %v, expected %v", width, grey, bounds, expected)
+			}
+			if cropped := one.Crop(one.Bounds()); cropped.Width != 1 || cropped.Height != 1 || cropped.Get(0, 0) != 255 {
+				t.Errorf("width %d grey %v: cropping to one cell gave %v", width, grey, cropped.Unpack())
+			}
+		}
+	}
+}
+
+func TestCropCopiesTheCellsInside(t *testing.T) {
+	random := rand.New(rand.NewSource(2))
+	rows := randomRows(random, 130, 9, false)
+	packed := Pack(rows, false)
+	r := Rect{Min: Cell{X: 60, Y: 2}, Max: Cell{X: 129, Y: 8}}
+	cropped := packed.Crop(r)
+	for y := 0; y < cropped.Height; y++ {
+		for x := 0; x < cropped.Width; x++ {
+			if cropped.Get(x, y) != rows[y+r.Min.Y][x+r.Min.X] {
+				t.Fatalf("cell %d,%d of the crop is %d, expected %d", x, y, cropped.Get(x, y), rows[y+r.Min.Y][x+r.Min.X])
+			}
+		}
+	}
+}
+
+func TestHashAddsUpOverStrips(t *testing.T) {
+	random := rand.New(rand.NewSource(3))
+	for _, grey := range []bool{false, true} {
+		packed := Pack(randomRows(random, 100, 12, grey), grey)
+		whole := packed.Hash(0)
+		for split := 0; split <= packed.Height; split++ {
+			if sum := packed.Rows(0, split).Hash(0) + packed.Rows(split, packed.Height).Hash(split); sum != whole {
+				t.Errorf("grey %v: strips split at row %d hash to %x, the whole world to %x", grey, split, sum, whole)
+			}
+		}
+
+		// moving the same rows somewhere else in the world changes the hash
+		if packed.Hash(1) == whole {
+			t.Errorf("grey %v: the hash doesn't depend on where the rows are", grey)
+		}
+		packed.Set(99, 11, 255-packed.Get(99, 11))
+		if packed.Hash(0) == whole {
+			t.Errorf("grey %v: changing a cell didn't change the hash", grey)
+		}
+	}
+}
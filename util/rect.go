@@ -0,0 +1,39 @@
+package util
+
+// Rect is the cells from Min up to but not including Max.
+type Rect struct {
+	Min, Max Cell
+}
+
+// Empty says whether the rectangle has no cells in it.
+func (r Rect) Empty() bool {
+	return r.Min.X >= r.Max.X || r.Min.Y >= r.Max.Y
+}
+
+// Union is the smallest rectangle holding both rectangles.
+func (r Rect) Union(s Rect) Rect {
+	if r.Empty() {
+		return s
+	}
+	if s.Empty() {
+		return r
+	}
+	if s.Min.X < r.Min.X {
+		r.Min.X = s.Min.X
+	}
+	if s.Min.Y < r.Min.Y {
+		r.Min.Y = s.Min.Y
+	}
+	if s.Max.X > r.Max.X {
+		r.Max.X = s.Max.X
+	}
+	if s.Max.Y > r.Max.Y {
+		r.Max.Y = s.Max.Y
+	}
+	return r
+}
+
+// Add moves the rectangle by d.
+func (r Rect) Add(d Cell) Rect {
+	return Rect{Min: Cell{X: r.Min.X + d.X, Y: r.Min.Y + d.Y}, Max: Cell{X: r.Max.X + d.X, Y: r.Max.Y + d.Y}}
+}
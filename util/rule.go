@@ -0,0 +1,419 @@
+package util
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Rule is a life-like rule, saying how many alive neighbours a cell needs to be born or to survive.
+// The zero Rule stands for Conway's Life, B3/S23, so anything that doesn't set one gets the usual game.
+//
+// A Generations rule has more than two States: a cell that doesn't survive spends States-2 turns dying
+// before it is dead, and can't be born again or count as a neighbour until then. Cells are kept as grey
+// levels, 255 for alive, 0 for dead and evenly spaced levels in between for each dying state.
+//
+// A Larger than Life rule has a Range of more than 1, so neighbours are counted further out.
+type Rule struct {
+	Born          []bool        // Born[n] says whether a dead cell with n alive neighbours comes alive
+	Survive       []bool        // Survive[n] says whether an alive cell with n alive neighbours stays alive
+	States        int           // how many states a cell has counting alive and dead, 0 is the same as 2
+	Neighbourhood Neighbourhood // which of the cells around a cell are its neighbours
+	Range         int           // how far out the neighbourhood goes, 0 is the same as 1
+	Middle        bool          // whether an alive cell counts itself as one of its neighbours
+}
+
+// Conway is the Game of Life's own rule.
+var Conway = MustParseRule("B3/S23")
+
+// ParseRule reads a rulestring such as B36/S23 or B2/S. Either half can come first, and the
+// older S/B form with bare digits (23/36) is understood too. A third part gives the number of
+// states of a Generations rule, as in Brian's Brain /2/3 or B2/S/C3. Ending the rulestring in
+// V or H counts neighbours in the von Neumann or hexagonal neighbourhood instead, as in B2/S34H.
+//
+// Larger than Life rules are given the same way as Golly, as in Bosco's Rule R5,C0,M1,S34..58,B34..45,NM.
+func ParseRule(s string) (Rule, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(strings.ToUpper(s), "R") && strings.Contains(s, ",") {
+		return parseLargerThanLife(s)
+	}
+
+	rule := Rule{States: 2}
+	body := s
+	if n := len(body); n > 0 {
+		switch body[n-1] {
+		case 'V', 'v':
+			rule.Neighbourhood = VonNeumann
+			body = body[:n-1]
+		case 'H', 'h':
+			rule.Neighbourhood = Hexagonal
+			body = body[:n-1]
+		}
+	}
+	most := rule.Neighbours()
+	rule.Born, rule.Survive = make([]bool, most+1), make([]bool, most+1)
+
+	halves := strings.Split(body, "/")
+	if len(halves) == 3 {
+		states := strings.TrimLeft(halves[2], "CcGg")
+		n, err := strconv.Atoi(states)
+		if err != nil || n < 2 || n > 256 || len(halves[2])-len(states) > 1 {
+			return Rule{}, fmt.Errorf("rule %q should end in a number of states from 2 to 256", s)
+		}
+		rule.States = n
+		halves = halves[:2]
+	}
+	if len(halves) != 2 {
+		return Rule{}, fmt.Errorf("rule %q should have two halves split by a /", s)
+	}
+
+	seen := map[byte]bool{}
+	for i, half := range halves {
+		kind := "SB"[i] // bare digits are survive/born
+		if half != "" && strings.ContainsRune("BbSs", rune(half[0])) {
+			kind = strings.ToUpper(half[:1])[0]
+			half = half[1:]
+		}
+		if seen[kind] {
+			return Rule{}, fmt.Errorf("rule %q gives %c twice", s, kind)
+		}
+		seen[kind] = true
+
+		counts := rule.Survive
+		if kind == 'B' {
+			counts = rule.Born
+		}
+		for _, digit := range half {
+			if digit < '0' || int(digit-'0') > most {
+				return Rule{}, fmt.Errorf("rule %q has %q where a neighbour count from 0 to %d should be", s, digit, most)
+			}
+			counts[digit-'0'] = true
+		}
+	}
+	return rule, nil
+}
+
+// parseLargerThanLife reads Golly's Larger than Life rulestrings, a comma separated list of
+// Rrange, Cstates, Mmiddle, Scounts, Bcounts and Nneighbourhood, where counts are ranges like 34..58.
+func parseLargerThanLife(s string) (Rule, error) {
+	rule := Rule{States: 2}
+	fail := func(why string) (Rule, error) {
+		return Rule{}, fmt.Errorf("rule %q %s", s, why)
+	}
+
+	counts := map[byte][]string{}
+	last := byte(0)
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			return fail("has an empty part")
+		}
+		kind := strings.ToUpper(field[:1])[0]
+		value := field[1:]
+		if kind >= '0' && kind <= '9' && (last == 'S' || last == 'B') {
+			counts[last] = append(counts[last], field) // more ranges for the counts before
+			continue
+		}
+		last = kind
+
+		var err error
+		switch kind {
+		case 'R':
+			rule.Range, err = strconv.Atoi(value)
+			if err != nil || rule.Range < 1 || rule.Range > 500 {
+				return fail("should have a range from 1 to 500")
+			}
+		case 'C':
+			rule.States, err = strconv.Atoi(value)
+			if err != nil || rule.States < 0 || rule.States > 256 {
+				return fail("should have a number of states from 0 to 256")
+			}
+			if rule.States < 2 {
+				rule.States = 2
+			}
+		case 'M':
+			if value != "0" && value != "1" {
+				return fail("should have M0 or M1")
+			}
+			rule.Middle = value == "1"
+		case 'S', 'B':
+			if value != "" {
+				counts[kind] = append(counts[kind], value)
+			}
+		case 'N':
+			switch strings.ToUpper(value) {
+			case "M":
+				rule.Neighbourhood = Moore
+			case "N":
+				rule.Neighbourhood = VonNeumann
+			case "H":
+				rule.Neighbourhood = Hexagonal
+			default:
+				return fail("should have a neighbourhood of NM, NN or NH")
+			}
+		default:
+			return fail(fmt.Sprintf("has %q, which isn't part of a Larger than Life rule", field))
+		}
+	}
+	if rule.Range == 0 {
+		return fail("needs a range")
+	}
+
+	most := rule.Neighbours()
+	if rule.Middle {
+		most++
+	}
+	rule.Born, rule.Survive = make([]bool, most+1), make([]bool, most+1)
+	for kind, set := range map[byte][]bool{'S': rule.Survive, 'B': rule.Born} {
+		for _, span := range counts[kind] {
+			bounds := strings.SplitN(span, "..", 2)
+			from, err := strconv.Atoi(bounds[0])
+			to := from
+			if err == nil && len(bounds) == 2 {
+				to, err = strconv.Atoi(bounds[1])
+			}
+			if err != nil || from < 0 || to < from || to > most {
+				return fail(fmt.Sprintf("has %c%s where a range of neighbour counts from 0 to %d should be", kind, span, most))
+			}
+			for n := from; n <= to; n++ {
+				set[n] = true
+			}
+		}
+	}
+	return rule, nil
+}
+
+// MustParseRule is ParseRule for rules known to be good, it panics on a bad one.
+func MustParseRule(s string) Rule {
+	rule, err := ParseRule(s)
+	Check(err)
+	return rule
+}
+
+// IsZero says whether the rule was never set, and so is Conway's.
+func (r Rule) IsZero() bool {
+	return r.Born == nil && r.Survive == nil && r.States == 0
+}
+
+// Generations says whether cells have dying states between alive and dead.
+func (r Rule) Generations() bool {
+	return r.States > 2
+}
+
+// AliveFrom is the lowest grey level that counts as an alive cell. Two state rules take any
+// level other than 0 as alive, Generations rules need 255 since anything lower is dying.
+func (r Rule) AliveFrom() uint8 {
+	if r.Generations() {
+		return 255
+	}
+	return 1
+}
+
+// Alive says whether a cell at the given grey level is alive.
+func (r Rule) Alive(level uint8) bool {
+	return level >= r.AliveFrom()
+}
+
+// OrConway gives back the rule, or Conway's if it was never set.
+func (r Rule) OrConway() Rule {
+	if r.IsZero() {
+		return Conway
+	}
+	return r
+}
+
+// Reach is how many cells out the neighbourhood goes, and so how many halo rows a strip of the world needs.
+func (r Rule) Reach() int {
+	if r.Range < 1 {
+		return 1
+	}
+	return r.Range
+}
+
+// Offsets lists where each neighbour is relative to the cell.
+func (r Rule) Offsets() []Cell {
+	return r.Neighbourhood.Offsets(r.Reach())
+}
+
+// Neighbours is how many neighbours each cell has, not counting itself.
+func (r Rule) Neighbours() int {
+	return len(r.Offsets())
+}
+
+// Classic says whether neighbours are the eight cells around a cell, as in Conway's Life.
+func (r Rule) Classic() bool {
+	return r.Neighbourhood == Moore && r.Reach() == 1 && !r.Middle
+}
+
+// Next says whether a cell is alive next turn.
+func (r Rule) Next(alive bool, neighbours int) bool {
+	if alive && r.Middle {
+		neighbours++
+	}
+	if alive {
+		return neighbours < len(r.Survive) && r.Survive[neighbours]
+	}
+	return neighbours < len(r.Born) && r.Born[neighbours]
+}
+
+// StepBits is Step for 64 cells at once, packed a bit a cell, for two state rules over the eight
+// cells around. Each of the neighbours words holds one of the eight neighbours of every cell in alive.
+func (r Rule) StepBits(alive uint64, neighbours [8]uint64) uint64 {
+	// add the neighbours up with the count of each cell spread a bit at a time over four words
+	var c0, c1, c2, c3 uint64
+	for _, n := range neighbours {
+		carry := c0 & n
+		c0 ^= n
+		carry2 := c1 & carry
+		c1 ^= carry
+		c3 |= c2 & carry2
+		c2 ^= carry2
+	}
+
+	var next uint64
+	for count := 0; count <= 8; count++ {
+		born, survive := r.Next(false, count), r.Next(true, count)
+		if !born && !survive {
+			continue
+		}
+		match := ^uint64(0)
+		for bit, c := range [4]uint64{c0, c1, c2, c3} {
+			if count>>uint(bit)&1 == 1 {
+				match &= c
+			} else {
+				match &^= c
+			}
+		}
+		if born {
+			next |= match &^ alive
+		}
+		if survive {
+			next |= match & alive
+		}
+	}
+	return next
+}
+
+// Step gives a cell's grey level next turn from its level now and how many alive neighbours it has.
+func (r Rule) Step(level uint8, neighbours int) uint8 {
+	if !r.Generations() {
+		if r.Next(level != 0, neighbours) {
+			return 255
+		}
+		return 0
+	}
+
+	if level == 0 {
+		if r.Next(false, neighbours) {
+			return 255
+		}
+		return 0
+	}
+	if level == 255 && r.Next(true, neighbours) {
+		return 255
+	}
+
+	// one gap further down, until there's no dying state left below, the last state isn't always the lowest gap up
+	gap := 255 / (r.States - 1)
+	if int(level)-gap < 255-(r.States-2)*gap {
+		return 0
+	}
+	return level - uint8(gap)
+}
+
+// State numbers the grey level of a cell the way pattern files do: 0 for dead, 1 for alive, and
+// 2 on for each dying state of a Generations rule in turn.
+func (r Rule) State(level uint8) int {
+	if level == 0 {
+		return 0
+	}
+	if !r.Generations() || level == 255 {
+		return 1
+	}
+	gap := 255 / (r.States - 1)
+	state := 1 + (255-int(level)+gap/2)/gap
+	if state > r.States-1 {
+		state = r.States - 1
+	}
+	return state
+}
+
+// Level is the grey level of a cell in a state numbered as State numbers them.
+// Without dying states every state other than 0 is alive.
+func (r Rule) Level(state int) uint8 {
+	if state <= 0 {
+		return 0
+	}
+	if !r.Generations() || state == 1 {
+		return 255
+	}
+	if state > r.States-1 {
+		state = r.States - 1
+	}
+	return uint8(255 - (state-1)*(255/(r.States-1)))
+}
+
+// String gives the rule back in B/S form, or Golly's form for a Larger than Life rule.
+func (r Rule) String() string {
+	r = r.OrConway()
+	if r.Reach() > 1 || r.Middle {
+		return r.largerThanLife()
+	}
+
+	digits := func(counts []bool) string {
+		var s strings.Builder
+		for n, ok := range counts {
+			if ok {
+				s.WriteString(fmt.Sprint(n))
+			}
+		}
+		return s.String()
+	}
+	s := "B" + digits(r.Born) + "/S" + digits(r.Survive)
+	if r.Generations() {
+		s += fmt.Sprintf("/C%d", r.States)
+	}
+	switch r.Neighbourhood {
+	case VonNeumann:
+		s += "V"
+	case Hexagonal:
+		s += "H"
+	}
+	return s
+}
+
+func (r Rule) largerThanLife() string {
+	spans := func(counts []bool) string {
+		var s []string
+		for from := 0; from < len(counts); from++ {
+			if !counts[from] {
+				continue
+			}
+			to := from
+			for to+1 < len(counts) && counts[to+1] {
+				to++
+			}
+			if to == from {
+				s = append(s, fmt.Sprint(from))
+			} else {
+				s = append(s, fmt.Sprintf("%d..%d", from, to))
+			}
+			from = to
+		}
+		return strings.Join(s, ",")
+	}
+	states, middle := 0, 0
+	if r.Generations() {
+		states = r.States
+	}
+	if r.Middle {
+		middle = 1
+	}
+	return fmt.Sprintf("R%d,C%d,M%d,S%s,B%s,N%c", r.Reach(), states, middle, spans(r.Survive), spans(r.Born), "MNH"[r.Neighbourhood])
+}
+
+// Set parses a rulestring into the rule, so it can be given as a flag.
+func (r *Rule) Set(s string) (err error) {
+	*r, err = ParseRule(s)
+	return
+}
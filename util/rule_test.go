@@ -0,0 +1,293 @@
+package util
+
+import (
+	"strings"
+	"testing"
+)
+
+// counts lists the neighbour counts that are set, as the digits of a rulestring.
+func counts(set []bool) string {
+	var s strings.Builder
+	for n, ok := range set {
+		if ok {
+			s.WriteByte(byte('0' + n))
+		}
+	}
+	return s.String()
+}
+
+func TestParseRule(t *testing.T) {
+	tests := []struct {
+		rule    string
+		born    string
+		survive string
+		states  int
+		bad     bool
+	}{
+		{rule: "B3/S23", born: "3", survive: "23", states: 2},
+		{rule: "B36/S23", born: "36", survive: "23", states: 2},
+		{rule: "b36/s23", born: "36", survive: "23", states: 2},
+		{rule: "B2/S", born: "2", survive: "", states: 2},
+		{rule: "S23/B36", born: "36", survive: "23", states: 2},
+		{rule: "23/36", born: "36", survive: "23", states: 2},
+		{rule: "/2/3", born: "2", survive: "", states: 3},
+		{rule: "345/2/4", born: "2", survive: "345", states: 4},
+		{rule: "B2/S/C3", born: "2", survive: "", states: 3},
+		{rule: "B3/S23/256", born: "3", survive: "23", states: 256},
+		{rule: "B9/S23", bad: true},
+		{rule: "B3/S239", bad: true},
+		{rule: "B3a/S23", bad: true},
+		{rule: "B3/B23", bad: true},
+		{rule: "B3S23", bad: true},
+		{rule: "B3/S23/1", bad: true},
+		{rule: "B3/S23/257", bad: true},
+		{rule: "B3/S23/CC3", bad: true},
+	}
+	for _, test := range tests {
+		t.Run(test.rule, func(t *testing.T) {
+			rule, err := ParseRule(test.rule)
+			if test.bad {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", rule)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if counts(rule.Born) != test.born || counts(rule.Survive) != test.survive || rule.States != test.states {
+				t.Errorf("got B%v/S%v with %v states, expected B%v/S%v with %v states",
+					counts(rule.Born), counts(rule.Survive), rule.States, test.born, test.survive, test.states)
+			}
+		})
+	}
+}
+
+// TestStepBits checks StepBits against Step for every two state rule over the eight cells around,
+// with each of the 64 cells having a different neighbour count and alive or dead.
+func TestStepBits(t *testing.T) {
+	var alive uint64
+	var neighbours [8]uint64
+	for bit := uint(0); bit < 64; bit++ {
+		count := int(bit % 9)
+		if bit/9%2 == 1 {
+			alive |= 1 << bit
+		}
+		for n := 0; n < count; n++ {
+			neighbours[n] |= 1 << bit
+		}
+	}
+
+	for mask := 0; mask < 1<<18; mask++ {
+		rule := Rule{Born: make([]bool, 9), Survive: make([]bool, 9), States: 2}
+		for n := 0; n < 9; n++ {
+			rule.Born[n] = mask>>uint(n)&1 == 1
+			rule.Survive[n] = mask>>uint(n+9)&1 == 1
+		}
+
+		next := rule.StepBits(alive, neighbours)
+		for bit := uint(0); bit < 64; bit++ {
+			level := uint8(0)
+			if alive>>bit&1 == 1 {
+				level = 255
+			}
+			expected := rule.Step(level, int(bit%9)) == 255
+			if got := next>>bit&1 == 1; got != expected {
+				t.Fatalf("%v with %d neighbours, alive %v: StepBits gives %v, Step gives %v", rule, bit%9, level == 255, got, expected)
+			}
+		}
+	}
+}
+
+func TestParseRuleNeighbourhood(t *testing.T) {
+	tests := []struct {
+		rule          string
+		neighbourhood Neighbourhood
+		neighbours    int
+	}{
+		{"B3/S23", Moore, 8},
+		{"B2/S34H", Hexagonal, 6},
+		{"B2/S34h", Hexagonal, 6},
+		{"B1/S1V", VonNeumann, 4},
+	}
+	for _, test := range tests {
+		rule, err := ParseRule(test.rule)
+		if err != nil {
+			t.Errorf("%v: %v", test.rule, err)
+			continue
+		}
+		if rule.Neighbourhood != test.neighbourhood || rule.Neighbours() != test.neighbours || len(rule.Born) != test.neighbours+1 {
+			t.Errorf("%v: got %v with %d neighbours, expected %v with %d", test.rule, rule.Neighbourhood, rule.Neighbours(), test.neighbourhood, test.neighbours)
+		}
+	}
+	// a hexagonal cell has only six neighbours to count
+	for _, bad := range []string{"B7/S23H", "B3/S5V"} {
+		if rule, err := ParseRule(bad); err == nil {
+			t.Errorf("%v: expected an error, got %v", bad, rule)
+		}
+	}
+}
+
+func TestParseLargerThanLife(t *testing.T) {
+	tests := []struct {
+		rule          string
+		reach         int
+		states        int
+		middle        bool
+		neighbourhood Neighbourhood
+		written       string // how String gives it back
+		bad           bool
+	}{
+		{rule: "R5,C0,M1,S34..58,B34..45,NM", reach: 5, states: 2, middle: true, neighbourhood: Moore, written: "R5,C0,M1,S34..58,B34..45,NM"},
+		{rule: "r2,c0,m0,s2..3,b3,nn", reach: 2, states: 2, neighbourhood: VonNeumann, written: "R2,C0,M0,S2..3,B3,NN"},
+		{rule: "R3,C4,M0,S2..5,8..9,B3,NH", reach: 3, states: 4, neighbourhood: Hexagonal, written: "R3,C4,M0,S2..5,8..9,B3,NH"},
+		{rule: "R2, C1, M0, S, B3, NM", reach: 2, states: 2, neighbourhood: Moore, written: "R2,C0,M0,S,B3,NM"},
+		{rule: "R2,B3,S4", reach: 2, states: 2, neighbourhood: Moore, written: "R2,C0,M0,S4,B3,NM"},
+		{rule: "R1,C0,M1,S9,B3,NM", reach: 1, states: 2, middle: true, neighbourhood: Moore, written: "R1,C0,M1,S9,B3,NM"},
+		{rule: "R0,C0,M0,S3,B3,NM", bad: true},
+		{rule: "R501,C0,M0,S3,B3,NM", bad: true},
+		{rule: "R,C0,M0,S3,B3,NM", bad: true},
+		{rule: "R2,C257,M0,S3,B3,NM", bad: true},
+		{rule: "R2,C-1,M0,S3,B3,NM", bad: true},
+		{rule: "R2,C0,M2,S3,B3,NM", bad: true},
+		{rule: "R2,C0,M0,S3..2,B3,NM", bad: true},
+		{rule: "R2,C0,M0,S-1,B3,NM", bad: true},
+		{rule: "R2,C0,M0,Sx..3,B3,NM", bad: true},
+		{rule: "R2,C0,M0,S3..,B3,NM", bad: true},
+		{rule: "R2,C0,M0,S25,B3,NM", bad: true},
+		{rule: "R1,C0,M0,S9,B3,NM", bad: true},
+		{rule: "R1,C0,M1,S10,B3,NM", bad: true},
+		{rule: "R2,C0,M0,S3,B3,NX", bad: true},
+		{rule: "R2,C0,M0,S3,B3,Q1", bad: true},
+		{rule: "R2,,S3,B3", bad: true},
+	}
+	for _, test := range tests {
+		t.Run(test.rule, func(t *testing.T) {
+			rule, err := ParseRule(test.rule)
+			if test.bad {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", rule)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rule.Reach() != test.reach || rule.States != test.states || rule.Middle != test.middle || rule.Neighbourhood != test.neighbourhood {
+				t.Errorf("got range %d, %d states, middle %v, %v, expected range %d, %d states, middle %v, %v",
+					rule.Reach(), rule.States, rule.Middle, rule.Neighbourhood, test.reach, test.states, test.middle, test.neighbourhood)
+			}
+			if rule.String() != test.written {
+				t.Errorf("written as %v, expected %v", rule, test.written)
+			}
+			if again, err := ParseRule(rule.String()); err != nil || again.String() != rule.String() {
+				t.Errorf("%v read back as %v, %v", rule, again, err)
+			}
+		})
+	}
+}
+
+// TestMiddle checks M1 counts an alive cell as one of its own neighbours, so S3..4 with it is S23 without.
+func TestMiddle(t *testing.T) {
+	middle := MustParseRule("R1,C0,M1,S3..4,B3,NM")
+	without := MustParseRule("R1,C0,M0,S2..3,B3,NM")
+	for _, alive := range []bool{false, true} {
+		for n := 0; n <= 8; n++ {
+			expected := Conway.Next(alive, n)
+			if got := middle.Next(alive, n); got != expected {
+				t.Errorf("M1, alive %v with %d neighbours: got %v, expected %v", alive, n, got, expected)
+			}
+			if got := without.Next(alive, n); got != expected {
+				t.Errorf("M0, alive %v with %d neighbours: got %v, expected %v", alive, n, got, expected)
+			}
+		}
+	}
+	if middle.Classic() || !without.Classic() {
+		t.Errorf("only the rule without M1 counts neighbours the classic way")
+	}
+}
+
+// generationsRules have dying states, with numbers of states that do and don't divide the grey levels evenly.
+var generationsRules = []Rule{
+	MustParseRule("/2/3"),
+	MustParseRule("345/2/4"),
+	MustParseRule("B3/S23/5"),
+	MustParseRule("B2/S/C7"),
+	MustParseRule("B3/S23/200"),
+	MustParseRule("B3/S23/256"),
+}
+
+func TestLevelRoundTrips(t *testing.T) {
+	for states := 2; states <= 256; states++ {
+		rule := Rule{States: states}
+		previous := 256
+		for state := 0; state < states; state++ {
+			level := rule.Level(state)
+			if got := rule.State(level); got != state {
+				t.Fatalf("%d states: state %d is level %d, which is state %d", states, state, level, got)
+			}
+			// alive is the brightest and each dying state darker than the one before
+			if state > 0 && int(level) >= previous {
+				t.Fatalf("%d states: state %d is level %d, no darker than the state before's %d", states, state, level, previous)
+			}
+			if state > 0 {
+				previous = int(level)
+			}
+		}
+		if rule.Level(0) != 0 || rule.Level(1) != 255 {
+			t.Fatalf("%d states: dead is level %d and alive %d", states, rule.Level(0), rule.Level(1))
+		}
+	}
+}
+
+func TestGenerationsStep(t *testing.T) {
+	brain := MustParseRule("/2/3")
+	dying := brain.Level(2)
+	tests := []struct {
+		level      uint8
+		neighbours int
+		expected   uint8
+	}{
+		{0, 2, 255},     // born
+		{0, 3, 0},       // not born
+		{255, 2, dying}, // nothing survives
+		{255, 0, dying},
+		{dying, 2, 0}, // dying cells die whatever is around them
+		{dying, 0, 0},
+	}
+	for _, test := range tests {
+		if got := brain.Step(test.level, test.neighbours); got != test.expected {
+			t.Errorf("%v: level %d with %d neighbours went to %d, expected %d", brain, test.level, test.neighbours, got, test.expected)
+		}
+	}
+}
+
+// TestGenerationsAgeing checks a cell that doesn't survive goes through every dying state in turn whatever
+// its neighbours, without coming back to life or dying early, and is dead after the last.
+func TestGenerationsAgeing(t *testing.T) {
+	for _, rule := range generationsRules {
+		for n := 0; n <= rule.Neighbours(); n++ {
+			if rule.Next(true, n) {
+				continue
+			}
+			level := rule.Step(255, n)
+			for state := 2; state < rule.States; state++ {
+				if level != rule.Level(state) {
+					t.Fatalf("%v: turn %d after dying with %d neighbours the cell is level %d, expected state %d's level %d",
+						rule, state-1, n, level, state, rule.Level(state))
+				}
+				// it ages the same whatever is around it
+				next := rule.Step(level, 0)
+				for m := 1; m <= rule.Neighbours(); m++ {
+					if got := rule.Step(level, m); got != next {
+						t.Fatalf("%v: level %d goes to %d with no neighbours but %d with %d", rule, level, next, got, m)
+					}
+				}
+				level = next
+			}
+			if level != 0 {
+				t.Errorf("%v: after every dying state the cell is level %d, not dead", rule, level)
+			}
+		}
+	}
+}
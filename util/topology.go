@@ -0,0 +1,133 @@
+package util
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Topology says how the edges of the world join up, and so what lies past them.
+// The zero Topology is the torus the Game of Life has always been played on.
+type Topology int
+
+const (
+	Torus              Topology = iota // left joins right and top joins bottom
+	Plane                              // nothing joins, everything past the edges is dead
+	HorizontalCylinder                 // left joins right, so the world wraps horizontally, top and bottom are dead
+	VerticalCylinder                   // top joins bottom, so the world wraps vertically, left and right are dead
+	KleinBottle                        // left joins right, top joins bottom with a twist so x is mirrored going over it
+	CrossSurface                       // both pairs join with a twist, the real projective plane
+	Unbounded                          // an endless plane, the world grows whenever anything comes near its edges
+)
+
+var topologyNames = map[Topology]string{
+	Torus:              "torus",
+	Plane:              "plane",
+	HorizontalCylinder: "hcylinder",
+	VerticalCylinder:   "vcylinder",
+	KleinBottle:        "klein",
+	CrossSurface:       "cross",
+	Unbounded:          "infinite",
+}
+
+// ParseTopology reads a topology by its name: torus, plane, hcylinder, vcylinder, klein, cross or infinite.
+func ParseTopology(s string) (Topology, error) {
+	for topology, name := range topologyNames {
+		if strings.EqualFold(strings.TrimSpace(s), name) {
+			return topology, nil
+		}
+	}
+	return Torus, fmt.Errorf("unknown topology %q, expected one of torus, plane, hcylinder, vcylinder, klein, cross or infinite", s)
+}
+
+func (t Topology) String() string {
+	if name, ok := topologyNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("Topology(%d)", int(t))
+}
+
+// Set parses a topology name into t, so it can be given as a flag.
+func (t *Topology) Set(s string) (err error) {
+	*t, err = ParseTopology(s)
+	return
+}
+
+func (t Topology) wrapsSides() bool {
+	return t == Torus || t == HorizontalCylinder || t == KleinBottle || t == CrossSurface
+}
+
+func (t Topology) wrapsEnds() bool {
+	return t == Torus || t == VerticalCylinder || t == KleinBottle || t == CrossSurface
+}
+
+// TwistsSides says whether going over the left or right edge mirrors y. Cells past the sides
+// are then in other rows entirely, so whoever holds a strip of rows needs the world's first
+// and last columns as well as its halo rows.
+func (t Topology) TwistsSides() bool {
+	return t == CrossSurface
+}
+
+// Grows says whether the world is a window on an endless plane, which has to be made bigger whenever
+// anything comes within reach of its edges. Until then nothing joins, the same as a Plane.
+func (t Topology) Grows() bool {
+	return t == Unbounded
+}
+
+func (t Topology) twistsEnds() bool {
+	return t == KleinBottle || t == CrossSurface
+}
+
+// Wrap finds the cell that x, y stands for when it is past the edges of a width x height world.
+// It returns false if the topology has nothing there, so the cell is always dead.
+func (t Topology) Wrap(x, y, width, height int) (int, int, bool) {
+	// a corner goes over one edge then the other
+	for i := 0; i < 2; i++ {
+		if x < 0 || x >= width {
+			if !t.wrapsSides() {
+				return x, y, false
+			}
+			if t.TwistsSides() {
+				y = height - 1 - y
+			}
+			x = (x%width + width) % width
+		} else if y < 0 || y >= height {
+			if !t.wrapsEnds() {
+				return x, y, false
+			}
+			if t.twistsEnds() {
+				x = width - 1 - x
+			}
+			y = (y%height + height) % height
+		}
+	}
+	return x, y, true
+}
+
+// OverEnd gives a first or last row of the world as it is seen from over the opposite edge,
+// to be used as a halo row there. The row is mirrored if the ends join with a twist,
+// or dead if they don't join at all.
+func (t Topology) OverEnd(row []uint8) []uint8 {
+	over := make([]uint8, len(row))
+	if !t.wrapsEnds() {
+		return over
+	}
+	for x := range row {
+		if t.twistsEnds() {
+			over[x] = row[len(row)-1-x]
+		} else {
+			over[x] = row[x]
+		}
+	}
+	return over
+}
+
+// OverEndRows is OverEnd for each of a few packed rows.
+func (t Topology) OverEndRows(rows Packed) Packed {
+	over := rows.Unpack()
+	for y := range over {
+		over[y] = t.OverEnd(over[y])
+	}
+	packed := Pack(over, rows.Grey)
+	packed.Width = rows.Width
+	return packed
+}
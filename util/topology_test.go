@@ -0,0 +1,158 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+)
+
+var topologies = []Topology{Torus, Plane, HorizontalCylinder, VerticalCylinder, KleinBottle, CrossSurface, Unbounded}
+
+func TestWrap(t *testing.T) {
+	const width, height = 5, 4
+	type wrapped struct {
+		x, y int
+		ok   bool
+	}
+	dead := wrapped{}
+	cells := []Cell{
+		{X: 2, Y: 1},   // inside
+		{X: -1, Y: 1},  // past the left
+		{X: 5, Y: 1},   // past the right
+		{X: 1, Y: -1},  // past the top
+		{X: 1, Y: 4},   // past the bottom
+		{X: -1, Y: -1}, // the corners
+		{X: 5, Y: -1},
+		{X: -1, Y: 4},
+		{X: 5, Y: 4},
+		{X: -2, Y: 0}, // further out, as a bigger neighbourhood reaches
+		{X: 0, Y: 5},
+	}
+	tests := []struct {
+		topology Topology
+		expected []wrapped
+	}{
+		{Torus, []wrapped{{2, 1, true}, {4, 1, true}, {0, 1, true}, {1, 3, true}, {1, 0, true}, {4, 3, true}, {0, 3, true}, {4, 0, true}, {0, 0, true}, {3, 0, true}, {0, 1, true}}},
+		{Plane, []wrapped{{2, 1, true}, dead, dead, dead, dead, dead, dead, dead, dead, dead, dead}},
+		{Unbounded, []wrapped{{2, 1, true}, dead, dead, dead, dead, dead, dead, dead, dead, dead, dead}},
+		{HorizontalCylinder, []wrapped{{2, 1, true}, {4, 1, true}, {0, 1, true}, dead, dead, dead, dead, dead, dead, {3, 0, true}, dead}},
+		{VerticalCylinder, []wrapped{{2, 1, true}, dead, dead, {1, 3, true}, {1, 0, true}, dead, dead, dead, dead, dead, {0, 1, true}}},
+		// going over the top or bottom mirrors x
+		{KleinBottle, []wrapped{{2, 1, true}, {4, 1, true}, {0, 1, true}, {3, 3, true}, {3, 0, true}, {0, 3, true}, {4, 3, true}, {0, 0, true}, {4, 0, true}, {3, 0, true}, {4, 1, true}}},
+		// going over the sides mirrors y as well, a corner goes over the side then the end, so mirrors both ways
+		{CrossSurface, []wrapped{{2, 1, true}, {4, 2, true}, {0, 2, true}, {3, 3, true}, {3, 0, true}, {0, 0, true}, {4, 0, true}, {0, 3, true}, {4, 3, true}, {3, 3, true}, {4, 1, true}}},
+	}
+	for _, test := range tests {
+		for i, cell := range cells {
+			x, y, ok := test.topology.Wrap(cell.X, cell.Y, width, height)
+			got := wrapped{x, y, ok}
+			if !ok {
+				got = dead
+			}
+			if got != test.expected[i] {
+				t.Errorf("%v: %d,%d wrapped to %v, expected %v", test.topology, cell.X, cell.Y, got, test.expected[i])
+			}
+		}
+	}
+}
+
+// TestWrapGoesBothWays checks that a cell's neighbour over an edge has the cell as its neighbour back over the same edge.
+func TestWrapGoesBothWays(t *testing.T) {
+	const width, height = 6, 5
+	for _, topology := range topologies {
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				for _, offset := range []Cell{{X: -1}, {X: 1}, {Y: -1}, {Y: 1}} {
+					wx, wy, ok := topology.Wrap(x+offset.X, y+offset.Y, width, height)
+					if !ok {
+						continue
+					}
+					found := false
+					for _, back := range []Cell{{X: -1}, {X: 1}, {Y: -1}, {Y: 1}} {
+						if bx, by, ok := topology.Wrap(wx+back.X, wy+back.Y, width, height); ok && bx == x && by == y {
+							found = true
+						}
+					}
+					if !found {
+						t.Errorf("%v: %d,%d has %d,%d as a neighbour, but not the other way round", topology, x, y, wx, wy)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestOverEnd(t *testing.T) {
+	row := []uint8{1, 2, 3, 0}
+	tests := map[Topology][]uint8{
+		Torus:              {1, 2, 3, 0},
+		Plane:              {0, 0, 0, 0},
+		Unbounded:          {0, 0, 0, 0},
+		HorizontalCylinder: {0, 0, 0, 0},
+		VerticalCylinder:   {1, 2, 3, 0},
+		KleinBottle:        {0, 3, 2, 1},
+		CrossSurface:       {0, 3, 2, 1},
+	}
+	for _, topology := range topologies {
+		if got := topology.OverEnd(row); !reflect.DeepEqual(got, tests[topology]) {
+			t.Errorf("%v: got %v, expected %v", topology, got, tests[topology])
+		}
+	}
+	if row[0] != 1 || row[3] != 0 {
+		t.Errorf("OverEnd changed the row it was given to %v", row)
+	}
+}
+
+// TestOverEndRows checks packed rows come out the same as OverEnd on each row, widths past a word and all.
+func TestOverEndRows(t *testing.T) {
+	rows := [][]uint8{make([]uint8, 70), make([]uint8, 70)}
+	rows[0][0], rows[0][65], rows[1][69] = 255, 255, 255
+	for _, grey := range []bool{false, true} {
+		for _, topology := range topologies {
+			expected := [][]uint8{topology.OverEnd(rows[0]), topology.OverEnd(rows[1])}
+			got := topology.OverEndRows(Pack(rows, grey))
+			if got.Width != 70 || got.Height != 2 || got.Grey != grey || !reflect.DeepEqual(got.Unpack(), expected) {
+				t.Errorf("%v grey %v: got %v, expected %v", topology, grey, got.Unpack(), expected)
+			}
+		}
+	}
+}
+
+// TestWrapMatchesOverEnd checks the halo rows OverEnd gives are the cells Wrap finds past the top and bottom.
+func TestWrapMatchesOverEnd(t *testing.T) {
+	const width, height = 5, 4
+	world := make([][]uint8, height)
+	for y := range world {
+		world[y] = make([]uint8, width)
+		for x := range world[y] {
+			world[y][x] = uint8(y*width + x + 1)
+		}
+	}
+	for _, topology := range topologies {
+		above, below := topology.OverEnd(world[height-1]), topology.OverEnd(world[0])
+		for x := 0; x < width; x++ {
+			for _, edge := range []struct {
+				y    int
+				halo []uint8
+			}{{-1, above}, {height, below}} {
+				expected := uint8(0)
+				if wx, wy, ok := topology.Wrap(x, edge.y, width, height); ok {
+					expected = world[wy][wx]
+				}
+				if edge.halo[x] != expected {
+					t.Errorf("%v: the halo at %d,%d is %d, Wrap finds %d", topology, x, edge.y, edge.halo[x], expected)
+				}
+			}
+		}
+	}
+}
+
+func TestParseTopology(t *testing.T) {
+	for _, topology := range topologies {
+		if parsed, err := ParseTopology(" " + topology.String() + " "); err != nil || parsed != topology {
+			t.Errorf("%v: parsed as %v, %v", topology, parsed, err)
+		}
+	}
+	if _, err := ParseTopology("sphere"); err == nil {
+		t.Errorf("expected an error for sphere")
+	}
+}